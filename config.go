@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+type Config struct {
+	Pair         string  `json:"pair"`
+	Market       string  `json:"market"`
+	Exchange     string  `json:"exchange"`
+	EntrySignal  string  `json:"entry_signal"`
+	MaxPosition  float64 `json:"max_position"`
+	UseTestnet   bool    `json:"use_testnet"`
+	PriceSource  string  `json:"price_source"`             // "last" (default), "bid_ask", "mid" or "mark" (futures only)
+	MaxSpreadBps float64 `json:"max_spread_bps,omitempty"` // 0 disables the spread guard
+
+	MaintenanceMarginRate float64 `json:"maintenance_margin_rate"` // used for the liquidation estimate, default 0.004
+
+	// Futures account settings, applied to the symbol at startup
+	Leverage     int    `json:"leverage,omitempty"`      // default 1
+	MarginType   string `json:"margin_type,omitempty"`   // "isolated" (default) or "crossed"
+	PositionMode string `json:"position_mode,omitempty"` // "one_way" (default) or "hedge"
+
+	// Position sizing; MaxPosition caps the notional of every model
+	SizingModel   string  `json:"sizing_model"`             // notional (default), quantity, equity_pct, risk or volatility
+	OrderQuantity float64 `json:"order_quantity,omitempty"` // quantity model: base asset amount
+	EquityPct     float64 `json:"equity_pct,omitempty"`     // equity_pct model: percent of equity
+	RiskPct       float64 `json:"risk_pct,omitempty"`       // risk model: percent of equity lost at the stop
+	VolTargetPct  float64 `json:"vol_target_pct,omitempty"` // volatility model: target daily volatility, percent of equity
+	VolLookback   int     `json:"vol_lookback,omitempty"`   // volatility model: 1m klines used, default 60
+
+	Endpoints    EndpointConfig `json:"endpoints"`                // custom REST and WebSocket endpoints, instead of mainnet or testnet
+	RecvWindowMs int            `json:"recv_window_ms,omitempty"` // how late a signed request may arrive, default Binance's 5000
+
+	DryRun bool `json:"-"` // set by the -dry-run flag: orders are checked and logged, not sent
+
+	Execution ExecutionConfig `json:"execution"`
+	Risk      RiskLimits      `json:"risk"`
+	Notify    NotifyConfig    `json:"notify"`
+}
+
+// ConfigErrors lists every problem found in a config file.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0]
+	}
+	return fmt.Sprintf("%d problems:\n  - %s", len(e), strings.Join(e, "\n  - "))
+}
+
+func (e *ConfigErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+var pairPattern = regexp.MustCompile(`^[A-Za-z0-9]+(_[A-Za-z0-9]+)?$`)
+
+// loadConfig reads, defaults and validates a config file. Every problem
+// found, including unknown fields, is reported in one ConfigErrors.
+func loadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ConfigErrors
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	errs = append(errs, unknownFields(raw, reflect.TypeOf(Config{}), "")...)
+
+	// A value of the wrong type leaves its field empty but the rest decoded,
+	// so validation still runs and every problem is reported in one go.
+	var config Config
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			errs.add("%v", err)
+			return nil, errs
+		}
+		errs = append(errs, typeErrors(raw, reflect.TypeOf(Config{}), "")...)
+	}
+
+	config.applyDefaults()
+	errs = append(errs, config.validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &config, nil
+}
+
+// applyDefaults fills in optional settings left empty.
+func (c *Config) applyDefaults() {
+	if c.PriceSource == "" {
+		c.PriceSource = "last"
+	}
+	if c.MaintenanceMarginRate == 0 {
+		c.MaintenanceMarginRate = 0.004
+	}
+	if c.SizingModel == "" {
+		c.SizingModel = sizingNotional
+	}
+	if c.SizingModel == sizingVolatility && c.VolLookback == 0 {
+		c.VolLookback = 60
+	}
+	c.Execution.applyDefaults()
+
+	if c.Market != "spot" {
+		if c.Leverage == 0 {
+			c.Leverage = 1
+		}
+		switch strings.ToUpper(c.MarginType) {
+		case "", "ISOLATED":
+			c.MarginType = "ISOLATED"
+		case "CROSS", "CROSSED":
+			c.MarginType = "CROSSED"
+		}
+		if c.PositionMode == "" {
+			c.PositionMode = "one_way"
+		}
+	}
+}
+
+func (c *Config) validate() ConfigErrors {
+	var errs ConfigErrors
+
+	if c.Exchange != "binance" {
+		errs.add("exchange must be binance, got %q", c.Exchange)
+	}
+
+	if c.Pair == "" {
+		errs.add("pair is required")
+	} else if !pairPattern.MatchString(c.Pair) {
+		errs.add("invalid pair: %q", c.Pair)
+	}
+
+	if _, ok := BINANCE_WS_BASE_URL_MAP[c.Market]; !ok {
+		errs.add("invalid market type: %q (spot, usdm or coinm)", c.Market)
+	}
+
+	if c.EntrySignal == "" {
+		errs.add("entry_signal is required: \"market\" or a price")
+	} else if c.EntrySignal != "market" {
+		price, err := strconv.ParseFloat(c.EntrySignal, 64)
+		if err != nil || price <= 0 {
+			errs.add("entry_signal must be \"market\" or a positive price, got %q", c.EntrySignal)
+		}
+	}
+
+	if c.MaxPosition <= 0 {
+		errs.add("max_position must be positive")
+	}
+	if c.MaxSpreadBps < 0 {
+		errs.add("max_spread_bps must not be negative")
+	}
+	if c.RecvWindowMs < 0 || c.RecvWindowMs > maxRecvWindowMs {
+		errs.add("recv_window_ms must be between 0 and %d", maxRecvWindowMs)
+	}
+	if c.MaintenanceMarginRate < 0 || c.MaintenanceMarginRate >= 1 {
+		errs.add("maintenance_margin_rate must be in [0, 1)")
+	}
+
+	switch c.PriceSource {
+	case "last", "bid_ask", "mid":
+	case "mark":
+		if c.Market == "spot" {
+			errs.add("price source mark is only available for futures markets")
+		}
+	default:
+		errs.add("invalid price source: %q", c.PriceSource)
+	}
+
+	switch c.SizingModel {
+	case sizingNotional:
+	case sizingQuantity:
+		if c.OrderQuantity <= 0 {
+			errs.add("sizing model quantity requires a positive order_quantity")
+		}
+	case sizingEquityPct:
+		if c.EquityPct <= 0 || c.EquityPct > 100 {
+			errs.add("sizing model equity_pct requires equity_pct in (0, 100]")
+		}
+	case sizingRisk:
+		if c.RiskPct <= 0 || c.RiskPct > 100 {
+			errs.add("sizing model risk requires risk_pct in (0, 100]")
+		}
+	case sizingVolatility:
+		if c.VolTargetPct <= 0 {
+			errs.add("sizing model volatility requires a positive vol_target_pct")
+		}
+		if c.VolLookback < 2 || c.VolLookback >= maxKlineHistory {
+			errs.add("vol_lookback must be between 2 and %d", maxKlineHistory-1)
+		}
+	default:
+		errs.add("invalid sizing model: %q", c.SizingModel)
+	}
+
+	if c.Risk.DailyLossLimit < 0 {
+		errs.add("risk.daily_loss_limit must not be negative")
+	}
+	if c.Risk.MaxOpenNotional < 0 {
+		errs.add("risk.max_open_notional must not be negative")
+	}
+	if c.Risk.MaxOrdersPerMinute < 0 {
+		errs.add("risk.max_orders_per_minute must not be negative")
+	}
+	if c.Risk.MaxConsecutiveLosses < 0 {
+		errs.add("risk.max_consecutive_losses must not be negative")
+	}
+
+	errs = append(errs, c.Endpoints.validate()...)
+	errs = append(errs, c.Execution.validate()...)
+	errs = append(errs, c.Notify.validate()...)
+
+	switch c.Market {
+	case "spot":
+		if c.Leverage != 0 || c.MarginType != "" || c.PositionMode != "" {
+			errs.add("leverage, margin_type and position_mode only apply to futures markets")
+		}
+	case "usdm", "coinm":
+		if c.Leverage < 1 || c.Leverage > 125 {
+			errs.add("invalid leverage: %d (1 to 125)", c.Leverage)
+		}
+		if c.MarginType != "ISOLATED" && c.MarginType != "CROSSED" {
+			errs.add("invalid margin type: %q (isolated or crossed)", c.MarginType)
+		}
+		if c.PositionMode != "one_way" && c.PositionMode != "hedge" {
+			errs.add("invalid position mode: %q (one_way or hedge)", c.PositionMode)
+		}
+	}
+
+	return errs
+}
+
+// unknownFields reports keys in data that match no json field of t, at any
+// depth. Keys match case-insensitively, as in encoding/json.
+func unknownFields(data json.RawMessage, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return nil
+		}
+		var problems []string
+		for i, item := range items {
+			problems = append(problems, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		value := object[key]
+		field, ok := jsonField(t, key)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown field %q", joinPath(path, key)))
+			continue
+		}
+		problems = append(problems, unknownFields(value, field.Type, joinPath(path, key))...)
+	}
+	return problems
+}
+
+// typeErrors reports values in data that do not fit the type of their json
+// field, at any depth. encoding/json only returns the first of them.
+func typeErrors(data json.RawMessage, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			break
+		}
+		var problems []string
+		for i, item := range items {
+			problems = append(problems, typeErrors(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			break
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var problems []string
+		for _, key := range keys {
+			if field, ok := jsonField(t, key); ok {
+				problems = append(problems, typeErrors(object[key], field.Type, joinPath(path, key))...)
+			}
+		}
+		return problems
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); errors.As(err, &typeErr) {
+		return []string{fmt.Sprintf("%s must be %s, got %s", path, jsonTypeName(t), typeErr.Value)}
+	}
+	return nil
+}
+
+// jsonTypeName describes the JSON a Go type decodes from.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return t.String()
+	}
+}
+
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// runValidate implements the validate subcommand: it loads every config
+// file in the directory and reports the problems of each. It returns the
+// process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configDir := fs.String("config", "config", "Directory containing JSON config files")
+	fs.Parse(args)
+
+	files, err := filepath.Glob(filepath.Join(*configDir, "*.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config directory: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No config files in %s\n", *configDir)
+		return 1
+	}
+
+	failed := 0
+	for _, file := range files {
+		if _, err := loadConfig(file); err != nil {
+			failed++
+			fmt.Println(color.RedString("FAIL %s: %v", file, err))
+			continue
+		}
+		fmt.Println(color.GreenString("ok   %s", file))
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d config files invalid\n", failed, len(files))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file into a temporary directory.
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	path := writeConfig(t, `{
+		"exchange": "binance",
+		"pair": "btcusdt",
+		"market": "spot",
+		"entry_signal": 65000,
+		"max_position": -1,
+		"leverage": "high",
+		"execution": {"twap_slices": "five"},
+		"notify": {"sinks": [{"type": "log", "events": "all"}]},
+		"colour": "red"
+	}`)
+
+	_, err := loadConfig(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("got %v, want ConfigErrors", err)
+	}
+	for _, want := range []string{
+		`unknown field "colour"`,
+		"entry_signal must be a string, got number",
+		"leverage must be an integer, got string",
+		"execution.twap_slices must be an integer, got string",
+		"notify.sinks[0].events must be a list, got string",
+		"max_position must be positive",
+	} {
+		if !containsProblem(errs, want) {
+			t.Errorf("missing %q in:\n%v", want, errs)
+		}
+	}
+}
+
+func TestLoadConfigSyntaxError(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, `{"pair": "btcusdt",}`))
+	if err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Fatalf("got %v, want an invalid JSON error", err)
+	}
+}
+
+func containsProblem(errs ConfigErrors, want string) bool {
+	for _, e := range errs {
+		if strings.Contains(e, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(c *Config)
+		wants []string // problems reported, none when empty
+	}{
+		{"valid spot", func(c *Config) {}, nil},
+		{"valid futures", func(c *Config) { c.Market, c.Leverage, c.MarginType = "usdm", 10, "cross" }, nil},
+		{"valid limit price", func(c *Config) { c.EntrySignal = "65000.5" }, nil},
+		{"wrong exchange", func(c *Config) { c.Exchange = "kraken" }, []string{`exchange must be binance, got "kraken"`}},
+		{"no pair", func(c *Config) { c.Pair = "" }, []string{"pair is required"}},
+		{"bad pair", func(c *Config) { c.Pair = "btc/usdt" }, []string{`invalid pair: "btc/usdt"`}},
+		{"bad market", func(c *Config) { c.Market = "margin" }, []string{`invalid market type: "margin"`}},
+		{"bad entry signal", func(c *Config) { c.EntrySignal = "-5" }, []string{`entry_signal must be "market" or a positive price, got "-5"`}},
+		{"zero max position", func(c *Config) { c.MaxPosition = 0 }, []string{"max_position must be positive"}},
+		{"recv window too long", func(c *Config) { c.RecvWindowMs = 90000 }, []string{"recv_window_ms must be between 0 and 60000"}},
+		{"mark price on spot", func(c *Config) { c.PriceSource = "mark" }, []string{"price source mark is only available for futures markets"}},
+		{"quantity model without quantity", func(c *Config) { c.SizingModel = sizingQuantity }, []string{"sizing model quantity requires a positive order_quantity"}},
+		{"equity over 100 percent", func(c *Config) { c.SizingModel, c.EquityPct = sizingEquityPct, 150 }, []string{"equity_pct in (0, 100]"}},
+		{"negative daily loss limit", func(c *Config) { c.Risk.DailyLossLimit = -1 }, []string{"risk.daily_loss_limit must not be negative"}},
+		{"leverage on spot", func(c *Config) { c.Leverage = 5 }, []string{"only apply to futures markets"}},
+		{"leverage too high", func(c *Config) { c.Market, c.Leverage = "coinm", 200 }, []string{"invalid leverage: 200"}},
+		{"bad execution entry", func(c *Config) { c.Execution.Entry = "vwap" }, []string{`invalid execution.entry: "vwap"`}},
+		{"webhook without url", func(c *Config) { c.Notify.Sinks = []NotifySinkConfig{{Type: "webhook"}} }, []string{"notify.sinks[0]: webhook requires a url"}},
+		{
+			"every problem at once",
+			func(c *Config) { c.Pair, c.MaxPosition, c.MaxSpreadBps = "", -1, -1 },
+			[]string{"pair is required", "max_position must be positive", "max_spread_bps must not be negative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Exchange: "binance", Pair: "btcusdt", Market: "spot", EntrySignal: "market", MaxPosition: 100}
+			tt.edit(&c)
+			c.applyDefaults()
+			errs := c.validate()
+			if len(tt.wants) == 0 && len(errs) > 0 {
+				t.Fatalf("unexpected problems: %v", errs)
+			}
+			if len(errs) != len(tt.wants) {
+				t.Errorf("got %d problems, want %d: %v", len(errs), len(tt.wants), errs)
+			}
+			for _, want := range tt.wants {
+				if !containsProblem(errs, want) {
+					t.Errorf("missing %q in: %v", want, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"none", `{"pair": "btcusdt", "execution": {"entry": "twap"}}`, nil},
+		{"keys match case-insensitively", `{"Pair": "btcusdt", "EXECUTION": {"Entry": "twap"}}`, nil},
+		{"top level", `{"pair": "btcusdt", "pairs": ["ethusdt"]}`, []string{`unknown field "pairs"`}},
+		{"nested", `{"risk": {"daily_loss": 5}}`, []string{`unknown field "risk.daily_loss"`}},
+		{"in a list", `{"notify": {"sinks": [{"type": "log"}, {"type": "slack", "channel": "#bots"}]}}`, []string{`unknown field "notify.sinks[1].channel"`}},
+		{"sorted", `{"zeta": 1, "alpha": 2}`, []string{`unknown field "alpha"`, `unknown field "zeta"`}},
+		{"flag-only field", `{"DryRun": true}`, []string{`unknown field "DryRun"`}},
+		{"wrong shape is left to the decoder", `{"execution": 5, "notify": {"sinks": {}}}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unknownFields(json.RawMessage(tt.data), reflect.TypeOf(Config{}), "")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ControlServer is the local HTTP/JSON API for a running bot. Every request
+// must carry "Authorization: Bearer <token>".
+//
+//	GET  /status
+//	POST /traders/{symbol}/pause
+//	POST /traders/{symbol}/resume
+//	POST /traders/{symbol}/flatten
+//	POST /traders/{symbol}/cancel-order
+//	GET  /traders/{symbol}/orders
+//	POST /traders/{symbol}/orders/cancel-all
+//	POST /traders/{symbol}/orders/{clientOrderId}/cancel
+//	POST /traders/{symbol}/orders/{clientOrderId}/replace  {"quantity": "<qty>", "price": "<price>"}
+//	POST /traders/{symbol}/entry-signal  {"entry_signal": "market" | "<price>"}
+//	POST /traders/{symbol}/direction     {"direction": "long" | "short"}
+type ControlServer struct {
+	token   string
+	risk    *RiskManager
+	traders map[string]*Trader // by upper-case symbol
+}
+
+func NewControlServer(token string, risk *RiskManager, traders []*Trader) (*ControlServer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("CONTROL_TOKEN must be set to enable the control API")
+	}
+
+	s := &ControlServer{token: token, risk: risk, traders: make(map[string]*Trader)}
+	for _, t := range traders {
+		s.traders[strings.ToUpper(t.config.Pair)] = t
+	}
+	return s, nil
+}
+
+func (s *ControlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /traders/{symbol}/pause", s.withTrader(s.handlePause))
+	mux.HandleFunc("POST /traders/{symbol}/resume", s.withTrader(s.handleResume))
+	mux.HandleFunc("POST /traders/{symbol}/flatten", s.withTrader(s.handleFlatten))
+	mux.HandleFunc("POST /traders/{symbol}/cancel-order", s.withTrader(s.handleCancelOrder))
+	mux.HandleFunc("GET /traders/{symbol}/orders", s.withTrader(s.handleOrders))
+	mux.HandleFunc("POST /traders/{symbol}/orders/cancel-all", s.withTrader(s.handleCancelAllOrders))
+	mux.HandleFunc("POST /traders/{symbol}/orders/{clientOrderId}/cancel", s.withTrader(s.handleCancelOpenOrder))
+	mux.HandleFunc("POST /traders/{symbol}/orders/{clientOrderId}/replace", s.withTrader(s.handleReplaceOrder))
+	mux.HandleFunc("POST /traders/{symbol}/entry-signal", s.withTrader(s.handleEntrySignal))
+	mux.HandleFunc("POST /traders/{symbol}/direction", s.withTrader(s.handleDirection))
+	return s.authenticate(mux)
+}
+
+// ListenAndServe runs the API on addr until the listener fails.
+func (s *ControlServer) ListenAndServe(addr string) {
+	controlLog.Info("serving control API", "addr", addr)
+	if err := http.ListenAndServe(addr, s.Handler()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		controlLog.Error("control API stopped", "err", err)
+	}
+}
+
+func (s *ControlServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			controlLog.Warn("unauthorized request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type traderHandler func(w http.ResponseWriter, r *http.Request, t *Trader)
+
+func (s *ControlServer) withTrader(h traderHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		symbol := strings.ToUpper(r.PathValue("symbol"))
+		t, ok := s.traders[symbol]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no trader for %s", symbol))
+			return
+		}
+		h(w, r, t)
+	}
+}
+
+type controlStatus struct {
+	Halted  bool           `json:"halted"`
+	Traders []TraderStatus `json:"traders"`
+}
+
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := controlStatus{Halted: s.risk.Halted()}
+	for _, t := range s.traders {
+		status.Traders = append(status.Traders, t.Status())
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *ControlServer) handlePause(w http.ResponseWriter, r *http.Request, t *Trader) {
+	t.Pause()
+	controlLog.Info("paused trader", "symbol", t.config.Pair, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+func (s *ControlServer) handleResume(w http.ResponseWriter, r *http.Request, t *Trader) {
+	t.Resume()
+	controlLog.Info("resumed trader", "symbol", t.config.Pair, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+// handleFlatten pauses the Trader before closing, so it does not re-enter
+// on the next tick; resume it to trade again.
+func (s *ControlServer) handleFlatten(w http.ResponseWriter, r *http.Request, t *Trader) {
+	controlLog.Warn("manual flatten", "symbol", t.config.Pair, "remote", r.RemoteAddr)
+	t.Pause()
+	t.Flatten("manual flatten via control API")
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+// handleCancelOrder stops the parent order the Trader is working. Its
+// fills stand; the Trader carries on from the next tick.
+func (s *ControlServer) handleCancelOrder(w http.ResponseWriter, r *http.Request, t *Trader) {
+	if !t.CancelParentOrder() {
+		writeError(w, http.StatusConflict, fmt.Errorf("no parent order is being worked"))
+		return
+	}
+	controlLog.Info("cancelled parent order", "symbol", t.config.Pair, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+func (s *ControlServer) handleOrders(w http.ResponseWriter, r *http.Request, t *Trader) {
+	writeJSON(w, http.StatusOK, t.OpenOrders())
+}
+
+func (s *ControlServer) handleCancelAllOrders(w http.ResponseWriter, r *http.Request, t *Trader) {
+	if err := t.CancelAllOrders(); errors.Is(err, errParentOrderWorking) {
+		writeError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	controlLog.Info("cancelled all orders", "symbol", t.config.Pair, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.OpenOrders())
+}
+
+func (s *ControlServer) handleCancelOpenOrder(w http.ResponseWriter, r *http.Request, t *Trader) {
+	id := r.PathValue("clientOrderId")
+	if err := t.CancelOrder(id); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	controlLog.Info("cancelled order", "symbol", t.config.Pair, "clientOrderId", id, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.OpenOrders())
+}
+
+func (s *ControlServer) handleReplaceOrder(w http.ResponseWriter, r *http.Request, t *Trader) {
+	var req struct {
+		Quantity string `json:"quantity"`
+		Price    string `json:"price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if req.Quantity == "" && req.Price == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("quantity or price is required"))
+		return
+	}
+	id := r.PathValue("clientOrderId")
+	order, err := t.ReplaceOrder(id, req.Quantity, req.Price)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	controlLog.Info("replaced order", "symbol", t.config.Pair, "clientOrderId", id, "newClientOrderId", order.ClientOrderID, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (s *ControlServer) handleEntrySignal(w http.ResponseWriter, r *http.Request, t *Trader) {
+	var req struct {
+		EntrySignal string `json:"entry_signal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if err := t.SetEntrySignal(req.EntrySignal); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	controlLog.Info("changed entry signal", "symbol", t.config.Pair, "entrySignal", req.EntrySignal, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+func (s *ControlServer) handleDirection(w http.ResponseWriter, r *http.Request, t *Trader) {
+	var req struct {
+		Direction string `json:"direction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if req.Direction != "long" && req.Direction != "short" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("direction must be long or short"))
+		return
+	}
+	if err := t.SetDirection(req.Direction == "long"); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	controlLog.Info("changed direction", "symbol", t.config.Pair, "direction", req.Direction, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		controlLog.Warn("error writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -2,9 +2,11 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"strconv"
 	"sync"
 	"time"
-	"strconv"
 )
 
 type MarketData struct {
@@ -27,12 +29,59 @@ type MarketData struct {
 	LastTradeID int64
 	IsBuyerMaker bool
 
+	// RecentTrades (for all markets), oldest first
+	RecentTrades []TradeTick
+
 	// Depth (for all markets)
 	LastUpdateID int64
 	Bids         [][2]float64
 	Asks         [][2]float64
+
+	// BookTicker (for all markets)
+	BookUpdateID int64
+	BidPrice     float64
+	BidQty       float64
+	AskPrice     float64
+	AskQty       float64
+	BookTime     time.Time
+
+	// MarkPrice (for usdm and coinm)
+	MarkPrice       float64
+	IndexPrice      float64
+	FundingRate     float64
+	NextFundingTime time.Time
+	MarkTime        time.Time
+
+	// Kline (latest candle of the subscribed interval)
+	Kline       Kline
+	KlineCloses []float64 // closes of the most recent closed klines, oldest first
+}
+
+type Kline struct {
+	Interval  string
+	OpenTime  time.Time
+	CloseTime time.Time
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
+	Closed    bool
 }
 
+type TradeTick struct {
+	Time       time.Time
+	Price      float64
+	Quantity   float64
+	BuyerMaker bool
+}
+
+// maxRecentTrades bounds the trade tape kept per symbol.
+const maxRecentTrades = 50
+
+// maxKlineHistory bounds the close history kept per symbol.
+const maxKlineHistory = 500
+
 type DataStore struct {
 	mu sync.RWMutex
 	data map[string]*MarketData
@@ -44,78 +93,255 @@ func NewDataStore() *DataStore {
 	}
 }
 
-func (ds *DataStore) UpdateTrade(symbol string, data map[string]interface{}) {
-	ds.mu.Lock()
-	defer ds.mu.Unlock()
-
+func (ds *DataStore) marketData(symbol string) *MarketData {
 	md, ok := ds.data[symbol]
 	if !ok {
 		md = &MarketData{Symbol: symbol}
 		ds.data[symbol] = md
 	}
+	return md
+}
+
+func (ds *DataStore) UpdateTrade(symbol string, ev *TradeEvent) error {
+	price, err := strconv.ParseFloat(ev.Price, 64)
+	if err != nil {
+		return fmt.Errorf("invalid trade price %q: %v", ev.Price, err)
+	}
+	quantity, err := strconv.ParseFloat(ev.Quantity, 64)
+	if err != nil {
+		return fmt.Errorf("invalid trade quantity %q: %v", ev.Quantity, err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 
+	md := ds.marketData(symbol)
 	md.mu.Lock()
 	defer md.mu.Unlock()
 
-	md.EventTime = time.Unix(0, int64(data["E"].(float64))*int64(time.Millisecond))
-	md.TradeID = int64(data["t"].(float64))
-	md.Price, _ = strconv.ParseFloat(data["p"].(string), 64)
-	md.Quantity, _ = strconv.ParseFloat(data["q"].(string), 64)
-	md.TradeTime = time.Unix(0, int64(data["T"].(float64))*int64(time.Millisecond))
-	md.IsBuyerMM = data["m"].(bool)
+	md.EventTime = msToTime(ev.EventTime)
+	md.TradeID = ev.TradeID
+	md.Price = price
+	md.Quantity = quantity
+	md.TradeTime = msToTime(ev.TradeTime)
+	md.IsBuyerMM = ev.IsBuyerMaker
+	md.addRecentTrade(TradeTick{Time: md.TradeTime, Price: price, Quantity: quantity, BuyerMaker: ev.IsBuyerMaker})
+	return nil
 }
 
-func (ds *DataStore) UpdateAggTrade(symbol string, data map[string]interface{}) {
+func (ds *DataStore) UpdateAggTrade(symbol string, ev *AggTradeEvent) error {
+	price, err := strconv.ParseFloat(ev.Price, 64)
+	if err != nil {
+		return fmt.Errorf("invalid aggTrade price %q: %v", ev.Price, err)
+	}
+	quantity, err := strconv.ParseFloat(ev.Quantity, 64)
+	if err != nil {
+		return fmt.Errorf("invalid aggTrade quantity %q: %v", ev.Quantity, err)
+	}
+
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	md, ok := ds.data[symbol]
-	if !ok {
-		md = &MarketData{Symbol: symbol}
-		ds.data[symbol] = md
+	md := ds.marketData(symbol)
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.EventTime = msToTime(ev.EventTime)
+	md.AggTradeID = ev.AggTradeID
+	md.Price = price
+	md.Quantity = quantity
+	md.FirstTradeID = ev.FirstTradeID
+	md.LastTradeID = ev.LastTradeID
+	md.TradeTime = msToTime(ev.TradeTime)
+	md.IsBuyerMaker = ev.IsBuyerMaker
+	md.addRecentTrade(TradeTick{Time: md.TradeTime, Price: price, Quantity: quantity, BuyerMaker: ev.IsBuyerMaker})
+	return nil
+}
+
+func (md *MarketData) addRecentTrade(tick TradeTick) {
+	if len(md.RecentTrades) >= maxRecentTrades {
+		md.RecentTrades = append(md.RecentTrades[:0], md.RecentTrades[1:]...)
 	}
+	md.RecentTrades = append(md.RecentTrades, tick)
+}
+
+func (ds *DataStore) UpdateDepth(symbol string, ev *DepthEvent) error {
+	updateID, bidLevels, askLevels := ev.LastUpdateID, ev.Bids, ev.Asks
+	if ev.EventType == "depthUpdate" {
+		updateID, bidLevels, askLevels = ev.FinalUpdateID, ev.FuturesBids, ev.FuturesAsks
+	}
+
+	bids, err := parseOrders(bidLevels)
+	if err != nil {
+		return fmt.Errorf("invalid bids: %v", err)
+	}
+	asks, err := parseOrders(askLevels)
+	if err != nil {
+		return fmt.Errorf("invalid asks: %v", err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 
+	md := ds.marketData(symbol)
 	md.mu.Lock()
 	defer md.mu.Unlock()
 
-	md.EventTime = time.Unix(0, int64(data["E"].(float64))*int64(time.Millisecond))
-	md.AggTradeID = int64(data["a"].(float64))
-	md.Price, _ = strconv.ParseFloat(data["p"].(string), 64)
-	md.Quantity, _ = strconv.ParseFloat(data["q"].(string), 64)
-	md.FirstTradeID = int64(data["f"].(float64))
-	md.LastTradeID = int64(data["l"].(float64))
-	md.TradeTime = time.Unix(0, int64(data["T"].(float64))*int64(time.Millisecond))
-	md.IsBuyerMaker = data["m"].(bool)
+	if ev.EventTime != 0 {
+		md.EventTime = msToTime(ev.EventTime)
+	}
+	md.LastUpdateID = updateID
+	md.Bids = bids
+	md.Asks = asks
+	return nil
 }
 
+func (ds *DataStore) UpdateBookTicker(symbol string, ev *BookTickerEvent) error {
+	var values [4]float64
+	for i, s := range [4]string{ev.BidPrice, ev.BidQty, ev.AskPrice, ev.AskQty} {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid bookTicker value %q: %v", s, err)
+		}
+		values[i] = v
+	}
 
-func (ds *DataStore) UpdateDepth(symbol string, data map[string]interface{}) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	md, ok := ds.data[symbol]
-	if !ok {
-		md = &MarketData{Symbol: symbol}
-		ds.data[symbol] = md
+	md := ds.marketData(symbol)
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.BookUpdateID = ev.UpdateID
+	md.BidPrice, md.BidQty, md.AskPrice, md.AskQty = values[0], values[1], values[2], values[3]
+	md.BookTime = time.Now()
+	return nil
+}
+
+func (ds *DataStore) UpdateMarkPrice(symbol string, ev *MarkPriceEvent) error {
+	markPrice, err := strconv.ParseFloat(ev.MarkPrice, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mark price %q: %v", ev.MarkPrice, err)
+	}
+	indexPrice, err := strconv.ParseFloat(ev.IndexPrice, 64)
+	if err != nil {
+		return fmt.Errorf("invalid index price %q: %v", ev.IndexPrice, err)
+	}
+	// Delivery contracts report an empty funding rate.
+	var fundingRate float64
+	if ev.FundingRate != "" {
+		fundingRate, err = strconv.ParseFloat(ev.FundingRate, 64)
+		if err != nil {
+			return fmt.Errorf("invalid funding rate %q: %v", ev.FundingRate, err)
+		}
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	md := ds.marketData(symbol)
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.MarkPrice = markPrice
+	md.IndexPrice = indexPrice
+	md.FundingRate = fundingRate
+	md.NextFundingTime = msToTime(ev.NextFundingTime)
+	md.MarkTime = msToTime(ev.EventTime)
+	return nil
+}
+
+func (ds *DataStore) UpdateKline(symbol string, ev *KlineEvent) error {
+	k := &ev.Kline
+	var values [5]float64
+	for i, s := range [5]string{k.Open, k.High, k.Low, k.Close, k.Volume} {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid kline value %q: %v", s, err)
+		}
+		values[i] = v
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	md := ds.marketData(symbol)
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.Kline = Kline{
+		Interval:  k.Interval,
+		OpenTime:  msToTime(k.StartTime),
+		CloseTime: msToTime(k.CloseTime),
+		Open:      values[0],
+		High:      values[1],
+		Low:       values[2],
+		Close:     values[3],
+		Volume:    values[4],
+		Closed:    k.IsClosed,
+	}
+	if k.IsClosed {
+		if len(md.KlineCloses) >= maxKlineHistory {
+			md.KlineCloses = append(md.KlineCloses[:0], md.KlineCloses[1:]...)
+		}
+		md.KlineCloses = append(md.KlineCloses, values[3])
+		dataLog.Debug("kline closed", "symbol", symbol, "interval", k.Interval, "close", values[3], "history", len(md.KlineCloses))
 	}
+	return nil
+}
 
+// SeedKlineCloses replaces the close history, used to backfill from REST
+// before the kline stream has produced enough closed candles.
+func (ds *DataStore) SeedKlineCloses(symbol string, closes []float64) {
+	if len(closes) > maxKlineHistory {
+		closes = closes[len(closes)-maxKlineHistory:]
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	md := ds.marketData(symbol)
 	md.mu.Lock()
 	defer md.mu.Unlock()
 
-	md.LastUpdateID = int64(data["lastUpdateId"].(float64))
-	md.Bids = parseOrders(data["bids"].([]interface{}))
-	md.Asks = parseOrders(data["asks"].([]interface{}))
+	md.KlineCloses = append([]float64(nil), closes...)
+	dataLog.Info("seeded kline history", "symbol", symbol, "closes", len(closes))
+}
+
+// KlineCloses returns a copy of the closed-kline close history for symbol.
+func (ds *DataStore) KlineCloses(symbol string) []float64 {
+	ds.mu.RLock()
+	md, ok := ds.data[symbol]
+	ds.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	return append([]float64(nil), md.KlineCloses...)
 }
 
-func parseOrders(orders []interface{}) [][2]float64 {
+func parseOrders(orders [][2]string) ([][2]float64, error) {
 	result := make([][2]float64, len(orders))
 	for i, order := range orders {
-		orderData := order.([]interface{})
-		price, _ := orderData[0].(string)
-		quantity, _ := orderData[1].(string)
-		result[i] = [2]float64{parseFloat(price), parseFloat(quantity)}
+		price, err := strconv.ParseFloat(order[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		quantity, err := strconv.ParseFloat(order[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = [2]float64{price, quantity}
 	}
-	return result
+	return result, nil
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
 }
 
 func (ds *DataStore) GetMarketData(symbol string) *MarketData {
@@ -125,3 +351,175 @@ func (ds *DataStore) GetMarketData(symbol string) *MarketData {
 	return ds.data[symbol]
 }
 
+// LastPrice returns the price of the most recent trade.
+func (md *MarketData) LastPrice() float64 {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	return md.Price
+}
+
+// BestBidAsk returns the top of book from the bookTicker stream, falling back
+// to the depth snapshot until the first bookTicker update arrives.
+func (md *MarketData) BestBidAsk() (bid, ask float64) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	if md.BidPrice > 0 && md.AskPrice > 0 {
+		return md.BidPrice, md.AskPrice
+	}
+	if len(md.Bids) > 0 && len(md.Asks) > 0 {
+		return md.Bids[0][0], md.Asks[0][0]
+	}
+	return 0, 0
+}
+
+// Book returns a copy of the depth snapshot, best levels first.
+func (md *MarketData) Book() (bids, asks [][2]float64) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	return append([][2]float64(nil), md.Bids...), append([][2]float64(nil), md.Asks...)
+}
+
+// Trades returns a copy of the recent trades, oldest first.
+func (md *MarketData) Trades() []TradeTick {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	return append([]TradeTick(nil), md.RecentTrades...)
+}
+
+// MarkPriceInfo returns the latest markPrice stream values.
+func (md *MarketData) MarkPriceInfo() (markPrice, indexPrice, fundingRate float64, nextFunding time.Time) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	return md.MarkPrice, md.IndexPrice, md.FundingRate, md.NextFundingTime
+}
+
+// SpreadBps returns the bid/ask spread in basis points of the mid price, or
+// -1 when no book data is available yet.
+func (md *MarketData) SpreadBps() float64 {
+	bid, ask := md.BestBidAsk()
+	if bid <= 0 || ask <= 0 {
+		return -1
+	}
+	mid := (bid + ask) / 2
+	return (ask - bid) / mid * 10000
+}
+
+// FillEstimate is the expected outcome of a market order walked through the
+// depth snapshot.
+type FillEstimate struct {
+	Quantity    float64 // quantity asked for
+	Covered     float64 // how much of it the visible book can fill
+	TouchPrice  float64 // best price on the side the order takes from
+	AvgPrice    float64 // average price of the covered quantity
+	WorstPrice  float64 // deepest level reached
+	SlippageBps float64 // AvgPrice away from TouchPrice, positive when worse
+}
+
+// Complete reports whether the visible book covers the whole quantity.
+func (e FillEstimate) Complete() bool {
+	return e.Covered >= e.Quantity*(1-1e-9)
+}
+
+// EstimateFill walks the asks (buys) or bids (sells) of symbol's depth
+// snapshot to estimate the average price and slippage of a market order for
+// quantity. Levels beyond the snapshot are unknown, so a large order may be
+// only partly covered.
+func (ds *DataStore) EstimateFill(symbol string, buy bool, quantity float64) (FillEstimate, error) {
+	md := ds.GetMarketData(symbol)
+	if md == nil {
+		return FillEstimate{}, fmt.Errorf("no market data for %s", symbol)
+	}
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	levels := md.Bids
+	if buy {
+		levels = md.Asks
+	}
+	if len(levels) == 0 {
+		return FillEstimate{}, fmt.Errorf("no order book data for %s", symbol)
+	}
+
+	e := FillEstimate{Quantity: quantity, TouchPrice: levels[0][0]}
+	var cost float64
+	for _, level := range levels {
+		if e.Covered >= quantity {
+			break
+		}
+		take := math.Min(level[1], quantity-e.Covered)
+		cost += take * level[0]
+		e.Covered += take
+		e.WorstPrice = level[0]
+	}
+	if e.Covered > 0 {
+		e.AvgPrice = cost / e.Covered
+		e.SlippageBps = slippageBps(buy, e.TouchPrice, e.AvgPrice)
+	}
+	return e, nil
+}
+
+// MaxFillQuantity returns the largest market order for symbol whose
+// estimated slippage stays within maxBps, limited to the visible book.
+func (ds *DataStore) MaxFillQuantity(symbol string, buy bool, maxBps float64) float64 {
+	md := ds.GetMarketData(symbol)
+	if md == nil {
+		return 0
+	}
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	levels := md.Bids
+	if buy {
+		levels = md.Asks
+	}
+	if len(levels) == 0 {
+		return 0
+	}
+
+	// The average price may reach limit; a level beyond it is taken only
+	// as far as the cheaper levels before it make up for.
+	limit := levels[0][0] * (1 - maxBps/10000)
+	if buy {
+		limit = levels[0][0] * (1 + maxBps/10000)
+	}
+	var quantity, cost float64
+	for _, level := range levels {
+		price, size := level[0], level[1]
+		if (buy && price <= limit) || (!buy && price >= limit) {
+			quantity += size
+			cost += price * size
+			continue
+		}
+		// Solve (cost + price*q) / (quantity + q) = limit for q. When the
+		// whole level fits, the average is still inside the limit and the
+		// next level may be reached too.
+		q := (limit*quantity - cost) / (price - limit)
+		if q <= 0 {
+			break
+		}
+		if q < size {
+			quantity += q
+			break
+		}
+		quantity += size
+		cost += price * size
+	}
+	return quantity
+}
+
+// slippageBps is how far price is from touch against the order, in basis
+// points: above it for buys, below it for sells.
+func slippageBps(buy bool, touch, price float64) float64 {
+	if touch <= 0 {
+		return 0
+	}
+	if buy {
+		return (price - touch) / touch * 10000
+	}
+	return (touch - price) / touch * 10000
+}
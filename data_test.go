@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// newTestBook returns a DataStore whose BTCUSDT book is thin enough to walk:
+// asks 1 at 100, 2 at 101, 2 at 103; bids 1 at 99, 2 at 98, 2 at 96.
+func newTestBook(t *testing.T) *DataStore {
+	t.Helper()
+	ds := NewDataStore()
+	err := ds.UpdateDepth("btcusdt", &DepthEvent{
+		LastUpdateID: 1,
+		Bids:         [][2]string{{"99", "1"}, {"98", "2"}, {"96", "2"}},
+		Asks:         [][2]string{{"100", "1"}, {"101", "2"}, {"103", "2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestEstimateFill(t *testing.T) {
+	ds := newTestBook(t)
+	tests := []struct {
+		name         string
+		buy          bool
+		quantity     float64
+		wantCovered  float64
+		wantAvg      float64
+		wantWorst    float64
+		wantSlippage float64
+	}{
+		{"inside the touch", true, 0.5, 0.5, 100, 100, 0},
+		{"two levels", true, 2, 2, 100.5, 101, 50},
+		{"whole side", true, 5, 5, 101.6, 103, 160},
+		{"past the book", true, 6, 5, 101.6, 103, 160},
+		{"sell two levels", false, 3, 3, 295.0 / 3, 98, (99 - 295.0/3) / 99 * 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ds.EstimateFill("btcusdt", tt.buy, tt.quantity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !approx(e.Covered, tt.wantCovered) || e.Complete() != (tt.wantCovered == tt.quantity) {
+				t.Errorf("covered %v (complete %v), want %v of %v", e.Covered, e.Complete(), tt.wantCovered, tt.quantity)
+			}
+			if !approx(e.AvgPrice, tt.wantAvg) || e.WorstPrice != tt.wantWorst {
+				t.Errorf("average %v, worst %v; want %v and %v", e.AvgPrice, e.WorstPrice, tt.wantAvg, tt.wantWorst)
+			}
+			if math.Abs(e.SlippageBps-tt.wantSlippage) > 1e-6 {
+				t.Errorf("slippage %v bps, want %v", e.SlippageBps, tt.wantSlippage)
+			}
+		})
+	}
+}
+
+func TestEstimateFillWithoutBook(t *testing.T) {
+	ds := NewDataStore()
+	if _, err := ds.EstimateFill("btcusdt", true, 1); err == nil {
+		t.Error("no market data: got an estimate")
+	}
+	if err := ds.UpdateDepth("btcusdt", &DepthEvent{Bids: [][2]string{{"99", "1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.EstimateFill("btcusdt", true, 1); err == nil {
+		t.Error("no asks: got an estimate for a buy")
+	}
+	if got := ds.MaxFillQuantity("btcusdt", true, 100); got != 0 {
+		t.Errorf("no asks: max buy %v, want 0", got)
+	}
+}
+
+func TestMaxFillQuantity(t *testing.T) {
+	ds := newTestBook(t)
+	tests := []struct {
+		name   string
+		buy    bool
+		maxBps float64
+		want   float64
+	}{
+		{"touch only", true, 0, 1},
+		{"into the second level", true, 50, 2},
+		{"part of the third level", true, 100, 3.5},
+		{"whole side", true, 1000, 5},
+		{"sell touch only", false, 0, 1},
+		// 1 at 99 and 2 at 98 average 98.33, inside the 98.01 limit, so
+		// part of the level at 96 is taken too.
+		{"sell past a whole level", false, 100, 3 + 0.97/2.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ds.MaxFillQuantity("btcusdt", tt.buy, tt.maxBps)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			// The estimate for that quantity sits within the limit.
+			e, err := ds.EstimateFill("btcusdt", tt.buy, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.SlippageBps > tt.maxBps+1e-6 {
+				t.Errorf("estimate for %v slips %v bps, over %v", got, e.SlippageBps, tt.maxBps)
+			}
+		})
+	}
+}
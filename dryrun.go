@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Order endpoints, used to log the request a dry run would have sent.
+var orderEndpoints = map[string]string{
+	"spot":  "/api/v3/order",
+	"usdm":  "/fapi/v1/order",
+	"coinm": "/dapi/v1/order",
+}
+
+// dryRunOrder stands in for sendOrder with -dry-run. It checks the order
+// against the symbol's filters and the account balance, logs the request
+// sendOrder would have made and fills it in full at the current book price:
+// the ask for buys, the bid for sells. A limit order fills at its price,
+// and only when the touch crosses it; otherwise it rests until it does (see
+// dryRunQuery) or is cancelled. A post-only order that would cross is
+// rejected on spot and expires on futures, as on the exchange. Dry-run
+// fills carry no fees.
+func (t *Trader) dryRunOrder(symbol string, p orderParams) (*orderResult, error) {
+	price := t.touchPrice(p.side)
+	if price <= 0 {
+		return nil, fmt.Errorf("dry run: no book price to fill at")
+	}
+	if err := t.checkFilters(p); err != nil {
+		return nil, fmt.Errorf("dry run: %v", err)
+	}
+	crosses := true
+	if p.orderType == orderTypeLimit {
+		limit, _ := strconv.ParseFloat(p.price, 64)
+		crosses = limitCrossed(p.side, limit, price)
+		price = limit
+	}
+	if err := t.checkBalance(p, price); err != nil {
+		return nil, fmt.Errorf("dry run: %v", err)
+	}
+
+	request := t.orderRequest(symbol, p)
+	args := []interface{}{"endpoint", "POST " + t.env.RestURL + orderEndpoints[t.config.Market]}
+	for _, key := range []string{"symbol", "side", "positionSide", "type", "timeInForce", "quantity", "price", "stopPrice", "newClientOrderId", "newOrderRespType"} {
+		if v, ok := request[key]; ok {
+			args = append(args, key, v)
+		}
+	}
+	exchangeLog.Info("dry run: order not sent", args...)
+
+	if p.postOnly && crosses && t.config.Market == "spot" {
+		return nil, &common.APIError{Code: errCodeNewOrderRejected, Message: "Order would immediately match and take."}
+	}
+
+	quantity, _ := strconv.ParseFloat(p.quantity, 64)
+	t.dryRunOrders++
+	orderID := t.dryRunOrders
+	switch {
+	case p.postOnly && crosses:
+		return &orderResult{orderID: orderID, status: string(binance.OrderStatusTypeExpired)}, nil
+	case !crosses:
+		if t.dryRunBook == nil {
+			t.dryRunBook = make(map[int64]*dryRunRestingOrder)
+		}
+		t.dryRunBook[orderID] = &dryRunRestingOrder{orderID: orderID, params: p, price: price, quantity: quantity, status: string(binance.OrderStatusTypeNew)}
+		return &orderResult{orderID: orderID, status: string(binance.OrderStatusTypeNew)}, nil
+	}
+	return dryRunFill(orderID, p.side, price, quantity), nil
+}
+
+// dryRunRestingOrder is a dry-run limit order waiting for the touch to
+// cross its price.
+type dryRunRestingOrder struct {
+	orderID  int64
+	params   orderParams
+	price    float64
+	quantity float64
+	status   string
+}
+
+// dryRunFill is the result of a dry-run order filled in full at price.
+func dryRunFill(orderID int64, side binance.SideType, price, quantity float64) *orderResult {
+	return &orderResult{
+		orderID:     orderID,
+		status:      string(binance.OrderStatusTypeFilled),
+		executedQty: quantity,
+		avgPrice:    price,
+		fills: []Fill{{
+			Time:     time.Now(),
+			OrderID:  orderID,
+			Side:     string(side),
+			Price:    price,
+			Quantity: quantity,
+		}},
+	}
+}
+
+// limitCrossed reports whether a limit order at limit would trade against
+// touch, the best price on the other side of the book.
+func limitCrossed(side binance.SideType, limit, touch float64) bool {
+	if touch <= 0 {
+		return false
+	}
+	if side == binance.SideTypeBuy {
+		return touch <= limit
+	}
+	return touch >= limit
+}
+
+// dryRunQuery stands in for queryOrder with -dry-run. A resting limit order
+// fills in full at its price once the touch has crossed it. Orders are
+// forgotten once they are reported done.
+func (t *Trader) dryRunQuery(orderID int64, clientOrderID string) (*orderResult, error) {
+	o := t.dryRunBook[orderID]
+	if orderID == 0 {
+		for _, resting := range t.dryRunBook {
+			if resting.params.clientOrderID == clientOrderID {
+				o = resting
+			}
+		}
+	}
+	if o == nil {
+		return nil, &common.APIError{Code: errCodeNoSuchOrder, Message: "Order does not exist."}
+	}
+
+	if o.status == string(binance.OrderStatusTypeNew) && limitCrossed(o.params.side, o.price, t.touchPrice(o.params.side)) {
+		o.status = string(binance.OrderStatusTypeFilled)
+		exchangeLog.Info("dry run: resting order filled", "orderId", o.orderID, "side", o.params.side, "price", o.price, "quantity", o.quantity)
+	}
+	res := &orderResult{orderID: o.orderID, status: o.status}
+	if o.status == string(binance.OrderStatusTypeFilled) {
+		res = dryRunFill(o.orderID, o.params.side, o.price, o.quantity)
+	}
+	if orderDone(o.status) {
+		delete(t.dryRunBook, o.orderID)
+		t.orders.forget(o.orderID)
+	}
+	return res, nil
+}
+
+// dryRunCancel stands in for cancelOrder with -dry-run.
+func (t *Trader) dryRunCancel(orderID int64) {
+	exchangeLog.Info("dry run: cancel order not sent", "symbol", strings.ToUpper(t.config.Pair), "orderId", orderID)
+	if o, ok := t.dryRunBook[orderID]; ok && o.status == string(binance.OrderStatusTypeNew) {
+		o.status = string(binance.OrderStatusTypeCanceled)
+	}
+	t.orders.forget(orderID)
+}
+
+// touchPrice is the price a market order for side would fill at first: the
+// ask for buys, the bid for sells, or the last trade without a book.
+func (t *Trader) touchPrice(side binance.SideType) float64 {
+	marketData := t.ds.GetMarketData(t.config.Pair)
+	if marketData == nil {
+		return 0
+	}
+	bid, ask := marketData.BestBidAsk()
+	if side == binance.SideTypeBuy && ask > 0 {
+		return ask
+	}
+	if side == binance.SideTypeSell && bid > 0 {
+		return bid
+	}
+	return marketData.LastPrice()
+}
+
+// orderRequest returns the parameters sendOrder sends for p, as the
+// exchange receives them.
+func (t *Trader) orderRequest(symbol string, p orderParams) map[string]string {
+	r := map[string]string{
+		"symbol":   symbol,
+		"side":     string(p.side),
+		"type":     p.orderType,
+		"quantity": p.quantity,
+
+		"newClientOrderId": p.clientOrderID,
+	}
+	switch p.orderType {
+	case orderTypeLimit:
+		r["timeInForce"] = "GTC"
+		r["price"] = p.price
+	case orderTypeStopLoss, orderTypeTakeProfit:
+		r["stopPrice"] = p.stopPrice
+	}
+
+	if t.config.Market == "spot" {
+		if p.postOnly {
+			r["type"] = "LIMIT_MAKER"
+			delete(r, "timeInForce")
+		}
+		r["newOrderRespType"] = "FULL"
+		return r
+	}
+	if p.postOnly {
+		r["timeInForce"] = "GTX"
+	}
+	// Futures call a stop-loss market order STOP.
+	if p.orderType == orderTypeStopLoss {
+		r["type"] = "STOP"
+	}
+	r["positionSide"] = t.positionSide(p.positionLong)
+	r["newOrderRespType"] = "RESULT"
+	return r
+}
+
+// checkFilters applies the exchange's LOT_SIZE, MARKET_LOT_SIZE,
+// PRICE_FILTER and minimum notional rules to an order.
+func (t *Trader) checkFilters(p orderParams) error {
+	f := t.symbol.filters
+
+	quantity, err := strconv.ParseFloat(p.quantity, 64)
+	if err != nil || quantity <= 0 {
+		return fmt.Errorf("invalid quantity %q", p.quantity)
+	}
+	minQty, maxQty, step := f.minQty, f.maxQty, f.stepSize
+	if p.orderType == orderTypeMarket && f.marketStepSize > 0 {
+		minQty, maxQty, step = f.marketMinQty, f.marketMaxQty, f.marketStepSize
+	}
+	if minQty > 0 && quantity < minQty {
+		return fmt.Errorf("quantity %s is below the minimum %g", p.quantity, minQty)
+	}
+	if maxQty > 0 && quantity > maxQty {
+		return fmt.Errorf("quantity %s is above the maximum %g", p.quantity, maxQty)
+	}
+	if !onStep(quantity, minQty, step) {
+		return fmt.Errorf("quantity %s is not a multiple of the step size %g", p.quantity, step)
+	}
+
+	for _, v := range []string{p.price, p.stopPrice} {
+		if v == "" {
+			continue
+		}
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price <= 0 {
+			return fmt.Errorf("invalid price %q", v)
+		}
+		if f.minPrice > 0 && price < f.minPrice {
+			return fmt.Errorf("price %s is below the minimum %g", v, f.minPrice)
+		}
+		if f.maxPrice > 0 && price > f.maxPrice {
+			return fmt.Errorf("price %s is above the maximum %g", v, f.maxPrice)
+		}
+		if !onStep(price, f.minPrice, f.tickSize) {
+			return fmt.Errorf("price %s is not a multiple of the tick size %g", v, f.tickSize)
+		}
+	}
+
+	if notional := t.orderNotional(p); f.minNotional > 0 && notional < f.minNotional {
+		return fmt.Errorf("notional %.8g is below the minimum %g", notional, f.minNotional)
+	}
+	return nil
+}
+
+// onStep reports whether v is min plus a whole number of steps.
+func onStep(v, min, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	n := (v - min) / step
+	return math.Abs(n-math.Round(n)) < 1e-6
+}
+
+// checkBalance verifies that the account could pay for an order opening or
+// adding to a position: the quote (buys) or base (sells) balance on spot,
+// the available margin at the current leverage on futures. Reducing orders
+// are not checked, since a dry run holds no real position.
+func (t *Trader) checkBalance(p orderParams, price float64) error {
+	if p.reducing {
+		return nil
+	}
+	quantity, _ := strconv.ParseFloat(p.quantity, 64)
+	notional := t.orderNotional(p)
+	ctx := context.Background()
+
+	var asset string
+	var available, need float64
+	switch t.config.Market {
+	case "spot":
+		account, err := t.spotClient.NewGetAccountService().Do(ctx, t.spotRecvWindow())
+		if err != nil {
+			return fmt.Errorf("error fetching spot balances: %v", err)
+		}
+		asset, need = t.symbol.quoteAsset, notional
+		if p.side == binance.SideTypeSell {
+			asset, need = t.symbol.baseAsset, quantity
+		}
+		for _, b := range account.Balances {
+			if b.Asset == asset {
+				available, _ = strconv.ParseFloat(b.Free, 64)
+			}
+		}
+	case "usdm":
+		account, err := t.usdmClient.NewGetAccountService().Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return fmt.Errorf("error fetching futures account: %v", err)
+		}
+		asset, need = t.symbol.marginAsset, notional/t.leverage
+		available, _ = strconv.ParseFloat(account.AvailableBalance, 64)
+	case "coinm":
+		account, err := t.coinmClient.NewGetAccountService().Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return fmt.Errorf("error fetching delivery account: %v", err)
+		}
+		// Margin is in the base coin.
+		asset, need = t.symbol.marginAsset, notional/t.leverage/price
+		for _, a := range account.Assets {
+			if a.Asset == asset {
+				available, _ = strconv.ParseFloat(a.AvailableBalance, 64)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+
+	if available < need {
+		return fmt.Errorf("insufficient %s balance: %.8g available, %.8g needed", asset, available, need)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/fatih/color"
+)
+
+var BINANCE_WS_BASE_URL_MAP = map[string]string{
+	"spot":  "stream.binance.com:9443",
+	"usdm":  "fstream.binance.com",
+	"coinm": "dstream.binance.com",
+}
+
+var BINANCE_TESTNET_WS_BASE_URL_MAP = map[string]string{
+	"spot":  "testnet.binance.vision",
+	"usdm":  "stream.binancefuture.com",
+	"coinm": "dstream.binancefuture.com",
+}
+
+var BINANCE_REST_BASE_URL_MAP = map[string]string{
+	"spot":  "https://api.binance.com",
+	"usdm":  "https://fapi.binance.com",
+	"coinm": "https://dapi.binance.com",
+}
+
+var BINANCE_TESTNET_REST_BASE_URL_MAP = map[string]string{
+	"spot":  "https://testnet.binance.vision",
+	"usdm":  "https://testnet.binancefuture.com",
+	"coinm": "https://testnet.binancefuture.com",
+}
+
+const (
+	envMainnet = "mainnet"
+	envTestnet = "testnet"
+	envCustom  = "custom"
+)
+
+// EndpointConfig overrides the Binance endpoints, e.g. for a proxy or a
+// mock exchange. An endpoint left empty falls back to mainnet or testnet
+// according to use_testnet.
+type EndpointConfig struct {
+	RestURL string `json:"rest_url,omitempty"` // e.g. https://api.example.com
+	WSURL   string `json:"ws_url,omitempty"`   // e.g. wss://stream.example.com:9443
+}
+
+func (c EndpointConfig) validate() ConfigErrors {
+	var errs ConfigErrors
+	if c.RestURL != "" {
+		if u, err := url.Parse(c.RestURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("endpoints.rest_url must be an http(s) URL, got %q", c.RestURL)
+		}
+	}
+	if c.WSURL != "" {
+		if u, err := url.Parse(c.WSURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			errs.add("endpoints.ws_url must be a ws(s) URL, got %q", c.WSURL)
+		}
+	}
+	return errs
+}
+
+// Environment is where a config's REST requests, orders and market data
+// streams go. Every Binance client and WebSocket connection is built from
+// it, so they can never point at different environments.
+type Environment struct {
+	Name    string // mainnet, testnet or custom
+	Market  string
+	RestURL string
+	WSURL   string // scheme and host, without the /stream path
+	DryRun  bool   // orders are logged, not sent
+}
+
+func resolveEnvironment(config *Config) Environment {
+	env := Environment{
+		Name:    envMainnet,
+		Market:  config.Market,
+		RestURL: BINANCE_REST_BASE_URL_MAP[config.Market],
+		WSURL:   "wss://" + BINANCE_WS_BASE_URL_MAP[config.Market],
+		DryRun:  config.DryRun,
+	}
+	if config.UseTestnet {
+		env.Name = envTestnet
+		env.RestURL = BINANCE_TESTNET_REST_BASE_URL_MAP[config.Market]
+		env.WSURL = "wss://" + BINANCE_TESTNET_WS_BASE_URL_MAP[config.Market]
+	}
+	if config.Endpoints.RestURL != "" {
+		env.Name = envCustom
+		env.RestURL = strings.TrimSuffix(config.Endpoints.RestURL, "/")
+	}
+	if config.Endpoints.WSURL != "" {
+		env.Name = envCustom
+		env.WSURL = strings.TrimSuffix(config.Endpoints.WSURL, "/")
+	}
+	return env
+}
+
+func (e Environment) SpotClient(apiKey, secretKey string) *binance.Client {
+	client := binance.NewClient(apiKey, secretKey)
+	client.BaseURL = e.RestURL
+	client.HTTPClient = restHTTPClient
+	client.TimeOffset = serverClock.Offset()
+	return client
+}
+
+func (e Environment) USDMClient(apiKey, secretKey string) *futures.Client {
+	client := futures.NewClient(apiKey, secretKey)
+	client.BaseURL = e.RestURL
+	client.HTTPClient = restHTTPClient
+	client.TimeOffset = serverClock.Offset()
+	return client
+}
+
+func (e Environment) CoinMClient(apiKey, secretKey string) *delivery.Client {
+	client := delivery.NewClient(apiKey, secretKey)
+	client.BaseURL = e.RestURL
+	client.HTTPClient = restHTTPClient
+	client.TimeOffset = serverClock.Offset()
+	return client
+}
+
+// StreamURL returns the combined stream URL for streams.
+func (e Environment) StreamURL(streams []string) (string, error) {
+	u, err := url.Parse(e.WSURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid WebSocket URL %q: %v", e.WSURL, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/stream"
+	u.RawQuery = "streams=" + strings.Join(streams, "/")
+	return u.String(), nil
+}
+
+// PrintBanner announces which environment orders will hit, in red when
+// they trade real funds and in cyan when they are not sent at all.
+func (e Environment) PrintBanner(pair string) {
+	var c *color.Color
+	var headline string
+	switch e.Name {
+	case envMainnet:
+		c = color.New(color.FgWhite, color.BgRed, color.Bold)
+		headline = "MAINNET - ORDERS USE REAL FUNDS"
+	case envTestnet:
+		c = color.New(color.FgBlack, color.BgYellow, color.Bold)
+		headline = "TESTNET - orders use test funds"
+	default:
+		c = color.New(color.FgWhite, color.BgMagenta, color.Bold)
+		headline = "CUSTOM ENDPOINTS - check where orders go"
+	}
+	if e.DryRun {
+		c = color.New(color.FgBlack, color.BgCyan, color.Bold)
+		headline = fmt.Sprintf("DRY RUN on %s data - orders are logged, not sent", strings.ToUpper(e.Name))
+	}
+
+	lines := []string{
+		headline,
+		fmt.Sprintf("%s %s", strings.ToUpper(pair), e.Market),
+		"REST: " + e.RestURL,
+		"WS:   " + e.WSURL,
+	}
+	width := 0
+	for _, l := range lines {
+		width = max(width, len(l))
+	}
+	border := strings.Repeat("=", width+4)
+
+	fmt.Println(c.Sprint(border))
+	for _, l := range lines {
+		fmt.Println(c.Sprintf("| %-*s |", width, l))
+	}
+	fmt.Println(c.Sprint(border))
+
+	exchangeLog.Info("trading environment", "env", e.Name, "dryRun", e.DryRun, "market", e.Market, "restUrl", e.RestURL, "wsUrl", e.WSURL)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Binance stream payloads. Every key a payload can carry is declared, even
+// the ones we ignore, because encoding/json matches keys case-insensitively
+// and an undeclared "M" would otherwise overwrite "m", "T" overwrite "t", etc.
+
+type TradeEvent struct {
+	EventType     string `json:"e"`
+	EventTime     int64  `json:"E"`
+	Symbol        string `json:"s"`
+	TradeID       int64  `json:"t"`
+	Price         string `json:"p"`
+	Quantity      string `json:"q"`
+	BuyerOrderID  int64  `json:"b"`
+	SellerOrderID int64  `json:"a"`
+	TradeTime     int64  `json:"T"`
+	IsBuyerMaker  bool   `json:"m"`
+	Ignore        bool   `json:"M"`
+}
+
+type AggTradeEvent struct {
+	EventType    string `json:"e"`
+	EventTime    int64  `json:"E"`
+	Symbol       string `json:"s"`
+	AggTradeID   int64  `json:"a"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	FirstTradeID int64  `json:"f"`
+	LastTradeID  int64  `json:"l"`
+	TradeTime    int64  `json:"T"`
+	IsBuyerMaker bool   `json:"m"`
+	Ignore       bool   `json:"M"`
+}
+
+// DepthEvent covers both the spot partial book (lastUpdateId/bids/asks) and
+// the futures depthUpdate payload (u/b/a).
+type DepthEvent struct {
+	EventType       string      `json:"e"`
+	EventTime       int64       `json:"E"`
+	TransactionTime int64       `json:"T"`
+	Symbol          string      `json:"s"`
+	Pair            string      `json:"ps"` // coinm only
+	FirstUpdateID   int64       `json:"U"`
+	FinalUpdateID   int64       `json:"u"`
+	PrevUpdateID    int64       `json:"pu"`
+	LastUpdateID    int64       `json:"lastUpdateId"`
+	Bids            [][2]string `json:"bids"`
+	Asks            [][2]string `json:"asks"`
+	FuturesBids     [][2]string `json:"b"`
+	FuturesAsks     [][2]string `json:"a"`
+}
+
+type BookTickerEvent struct {
+	EventType       string `json:"e"`
+	EventTime       int64  `json:"E"`
+	TransactionTime int64  `json:"T"`
+	UpdateID        int64  `json:"u"`
+	Symbol          string `json:"s"`
+	Pair            string `json:"ps"` // coinm only
+	BidPrice        string `json:"b"`
+	BidQty          string `json:"B"`
+	AskPrice        string `json:"a"`
+	AskQty          string `json:"A"`
+}
+
+type MarkPriceEvent struct {
+	EventType            string `json:"e"`
+	EventTime            int64  `json:"E"`
+	Symbol               string `json:"s"`
+	MarkPrice            string `json:"p"`
+	IndexPrice           string `json:"i"`
+	EstimatedSettlePrice string `json:"P"`
+	FundingRate          string `json:"r"`
+	NextFundingTime      int64  `json:"T"`
+}
+
+type KlineEvent struct {
+	EventType string       `json:"e"`
+	EventTime int64        `json:"E"`
+	Symbol    string       `json:"s"`
+	Kline     KlinePayload `json:"k"`
+}
+
+type KlinePayload struct {
+	StartTime           int64  `json:"t"`
+	CloseTime           int64  `json:"T"`
+	Symbol              string `json:"s"`
+	Interval            string `json:"i"`
+	FirstTradeID        int64  `json:"f"`
+	LastTradeID         int64  `json:"L"`
+	Open                string `json:"o"`
+	Close               string `json:"c"`
+	High                string `json:"h"`
+	Low                 string `json:"l"`
+	Volume              string `json:"v"`
+	TradeCount          int64  `json:"n"`
+	IsClosed            bool   `json:"x"`
+	QuoteVolume         string `json:"q"`
+	TakerBuyBaseVolume  string `json:"V"`
+	TakerBuyQuoteVolume string `json:"Q"`
+	Ignore              string `json:"B"`
+}
+
+// streamKind identifies a combined stream name such as "btcusdt@depth10@100ms".
+type streamKind int
+
+const (
+	streamUnknown streamKind = iota
+	streamTrade
+	streamAggTrade
+	streamDepth
+	streamBookTicker
+	streamMarkPrice
+	streamKline
+)
+
+func parseStreamKind(stream string) streamKind {
+	i := strings.IndexByte(stream, '@')
+	if i < 0 {
+		return streamUnknown
+	}
+	name := stream[i+1:]
+	if j := strings.IndexByte(name, '@'); j >= 0 {
+		name = name[:j]
+	}
+
+	switch {
+	case name == "trade":
+		return streamTrade
+	case name == "aggTrade":
+		return streamAggTrade
+	case strings.HasPrefix(name, "depth"):
+		return streamDepth
+	case name == "bookTicker":
+		return streamBookTicker
+	case name == "markPrice":
+		return streamMarkPrice
+	case strings.HasPrefix(name, "kline_"):
+		return streamKline
+	default:
+		return streamUnknown
+	}
+}
+
+// frameDecoder decodes combined-stream frames ({"stream":..,"data":..}) in a
+// single pass, straight into one reusable typed event per stream kind. It is
+// not safe for concurrent use; each WebSocket reader owns one.
+type frameDecoder struct {
+	reader bytes.Reader
+
+	trade      TradeEvent
+	aggTrade   AggTradeEvent
+	depth      DepthEvent
+	bookTicker BookTickerEvent
+	markPrice  MarkPriceEvent
+	kline      KlineEvent
+}
+
+// Decode parses a frame and returns the stream name, its kind and a pointer
+// to the decoded event. The returned event is only valid until the next call.
+func (d *frameDecoder) Decode(message []byte) (string, streamKind, interface{}, error) {
+	d.reader.Reset(message)
+	dec := json.NewDecoder(&d.reader)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return "", streamUnknown, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return "", streamUnknown, nil, fmt.Errorf("expected object, got %v", tok)
+	}
+
+	var stream string
+	var kind streamKind
+	var event interface{}
+	var pending json.RawMessage
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", streamUnknown, nil, err
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "stream":
+			if err := dec.Decode(&stream); err != nil {
+				return "", streamUnknown, nil, fmt.Errorf("decoding stream name: %v", err)
+			}
+			kind = parseStreamKind(stream)
+		case "data":
+			// Binance sends "stream" first, so this is normally a direct
+			// decode; buffer the payload only if the order is ever reversed.
+			if stream == "" {
+				if err := dec.Decode(&pending); err != nil {
+					return "", streamUnknown, nil, fmt.Errorf("decoding data: %v", err)
+				}
+				continue
+			}
+			event, err = d.decodeEvent(kind, dec.Decode)
+			if err != nil {
+				return stream, kind, nil, err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return "", streamUnknown, nil, err
+			}
+		}
+	}
+
+	if stream == "" {
+		return "", streamUnknown, nil, fmt.Errorf("frame has no stream name")
+	}
+	if event == nil && pending != nil {
+		event, err = d.decodeEvent(kind, func(v interface{}) error {
+			return json.Unmarshal(pending, v)
+		})
+		if err != nil {
+			return stream, kind, nil, err
+		}
+	}
+	if event == nil && kind != streamUnknown {
+		return stream, kind, nil, fmt.Errorf("frame for %s has no data", stream)
+	}
+
+	return stream, kind, event, nil
+}
+
+func (d *frameDecoder) decodeEvent(kind streamKind, decode func(interface{}) error) (interface{}, error) {
+	var event interface{}
+
+	switch kind {
+	case streamTrade:
+		d.trade = TradeEvent{}
+		event = &d.trade
+	case streamAggTrade:
+		d.aggTrade = AggTradeEvent{}
+		event = &d.aggTrade
+	case streamDepth:
+		d.depth = DepthEvent{Bids: d.depth.Bids[:0], Asks: d.depth.Asks[:0], FuturesBids: d.depth.FuturesBids[:0], FuturesAsks: d.depth.FuturesAsks[:0]}
+		event = &d.depth
+	case streamBookTicker:
+		d.bookTicker = BookTickerEvent{}
+		event = &d.bookTicker
+	case streamMarkPrice:
+		d.markPrice = MarkPriceEvent{}
+		event = &d.markPrice
+	case streamKline:
+		d.kline = KlineEvent{}
+		event = &d.kline
+	default:
+		var skip json.RawMessage
+		return nil, decode(&skip)
+	}
+
+	if err := decode(event); err != nil {
+		return nil, fmt.Errorf("decoding %T: %v", event, err)
+	}
+	return event, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Frames as Binance sends them on busy symbols.
+var benchFrames = []struct {
+	name  string
+	frame string
+}{
+	{"trade", `{"stream":"btcusdt@trade","data":{"e":"trade","E":1718000000123,"s":"BTCUSDT","t":3612345678,"p":"67012.34000000","q":"0.00150000","b":28123456789,"a":28123456790,"T":1718000000122,"m":true,"M":true}}`},
+	{"aggTrade", `{"stream":"btcusdt@aggTrade","data":{"e":"aggTrade","E":1718000000123,"a":2123456789,"s":"BTCUSDT","p":"67012.30","q":"0.015","f":5123456789,"l":5123456791,"T":1718000000122,"m":false}}`},
+	{"depth10", `{"stream":"btcusdt@depth10","data":{"lastUpdateId":48123456789,"bids":[["67012.33000000","1.20530000"],["67012.32000000","0.00100000"],["67012.10000000","0.05000000"],["67011.98000000","0.32000000"],["67011.50000000","0.00800000"],["67011.49000000","0.11940000"],["67011.00000000","0.40000000"],["67010.87000000","0.00300000"],["67010.86000000","0.15000000"],["67010.50000000","1.00000000"]],"asks":[["67012.34000000","3.49210000"],["67012.35000000","0.00200000"],["67012.60000000","0.01500000"],["67012.88000000","0.25000000"],["67013.00000000","0.00900000"],["67013.20000000","0.40000000"],["67013.44000000","0.07460000"],["67013.90000000","0.00100000"],["67014.00000000","0.80000000"],["67014.12000000","0.02000000"]]}}`},
+	{"depth10Futures", `{"stream":"btcusdt@depth10@100ms","data":{"e":"depthUpdate","E":1718000000123,"T":1718000000119,"s":"BTCUSDT","U":4812345678901,"u":4812345679012,"pu":4812345678900,"b":[["67000.10","12.345"],["67000.00","0.870"],["66999.90","1.002"],["66999.80","0.010"],["66999.70","3.400"],["66999.60","0.250"],["66999.50","0.006"],["66999.40","2.100"],["66999.30","0.050"],["66999.20","0.700"]],"a":[["67000.20","5.432"],["67000.30","0.120"],["67000.40","0.900"],["67000.50","1.750"],["67000.60","0.003"],["67000.70","0.480"],["67000.80","2.000"],["67000.90","0.030"],["67001.00","6.100"],["67001.10","0.095"]]}}`},
+	{"bookTicker", `{"stream":"btcusdt@bookTicker","data":{"e":"bookTicker","u":4812345679012,"s":"BTCUSDT","b":"67000.10","B":"12.345","a":"67000.20","A":"5.432","T":1718000000119,"E":1718000000123}}`},
+	{"markPrice", `{"stream":"btcusdt@markPrice@1s","data":{"e":"markPriceUpdate","E":1718000000000,"s":"BTCUSDT","p":"67001.23456789","P":"66998.80112345","i":"67003.11250000","r":"0.00010000","T":1718006400000}}`},
+}
+
+func BenchmarkFrameDecoder(b *testing.B) {
+	for _, f := range benchFrames {
+		message := []byte(f.frame)
+		b.Run(f.name, func(b *testing.B) {
+			var d frameDecoder
+			b.ReportAllocs()
+			b.SetBytes(int64(len(message)))
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := d.Decode(message); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// A futures connection interleaves its streams.
+	b.Run("mixed", func(b *testing.B) {
+		var d frameDecoder
+		var size int64
+		messages := make([][]byte, len(benchFrames))
+		for i, f := range benchFrames {
+			messages[i] = []byte(f.frame)
+			size += int64(len(f.frame))
+		}
+		b.ReportAllocs()
+		b.SetBytes(size / int64(len(messages)))
+		for i := 0; i < b.N; i++ {
+			if _, _, _, err := d.Decode(messages[i%len(messages)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestFrameDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		kind    streamKind
+		wantErr string // empty when the frame decodes
+	}{
+		{"malformed data", `{"stream":"btcusdt@trade","data":{"e":"trade","p":67012.34}}`, streamTrade, "decoding *main.TradeEvent"},
+		{"truncated data", `{"stream":"btcusdt@bookTicker","data":{"b":"67000.10",`, streamBookTicker, "decoding *main.BookTickerEvent"},
+		{"data not an object", `{"stream":"btcusdt@depth10","data":[1,2,3]}`, streamDepth, "decoding *main.DepthEvent"},
+		{"no data", `{"stream":"btcusdt@markPrice@1s"}`, streamMarkPrice, "has no data"},
+		{"no stream", `{"data":{"e":"trade"}}`, streamUnknown, "no stream name"},
+		{"not an object", `["btcusdt@trade"]`, streamUnknown, "expected object"},
+		{"unknown stream", `{"stream":"btcusdt@forceOrder","data":{"e":"forceOrder","o":{"s":"BTCUSDT"}}}`, streamUnknown, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d frameDecoder
+			_, kind, event, err := d.Decode([]byte(tt.frame))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if kind != tt.kind || event != nil {
+					t.Fatalf("got kind %v, event %v; want kind %v and no event", kind, event, tt.kind)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+			if kind != tt.kind {
+				t.Errorf("got kind %v, want %v", kind, tt.kind)
+			}
+			if event != nil {
+				t.Errorf("got event %v, want none", event)
+			}
+		})
+	}
+}
+
+func TestFrameDecoderValues(t *testing.T) {
+	// One decoder for every frame, as on a connection, so values left over
+	// from the previous frame of a kind would show.
+	var d frameDecoder
+	decode := func(frame string) (string, streamKind, interface{}) {
+		t.Helper()
+		stream, kind, event, err := d.Decode([]byte(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stream, kind, event
+	}
+
+	tests := []struct {
+		frame  string
+		stream string
+		kind   streamKind
+		want   interface{}
+	}{
+		{benchFrames[0].frame, "btcusdt@trade", streamTrade, &TradeEvent{
+			EventType: "trade", EventTime: 1718000000123, Symbol: "BTCUSDT", TradeID: 3612345678, Price: "67012.34000000", Quantity: "0.00150000",
+			BuyerOrderID: 28123456789, SellerOrderID: 28123456790, TradeTime: 1718000000122, IsBuyerMaker: true, Ignore: true,
+		}},
+		{benchFrames[1].frame, "btcusdt@aggTrade", streamAggTrade, &AggTradeEvent{
+			EventType: "aggTrade", EventTime: 1718000000123, Symbol: "BTCUSDT", AggTradeID: 2123456789, Price: "67012.30", Quantity: "0.015",
+			FirstTradeID: 5123456789, LastTradeID: 5123456791, TradeTime: 1718000000122,
+		}},
+		{benchFrames[4].frame, "btcusdt@bookTicker", streamBookTicker, &BookTickerEvent{
+			EventType: "bookTicker", EventTime: 1718000000123, TransactionTime: 1718000000119, UpdateID: 4812345679012, Symbol: "BTCUSDT",
+			BidPrice: "67000.10", BidQty: "12.345", AskPrice: "67000.20", AskQty: "5.432",
+		}},
+		{benchFrames[5].frame, "btcusdt@markPrice@1s", streamMarkPrice, &MarkPriceEvent{
+			EventType: "markPriceUpdate", EventTime: 1718000000000, Symbol: "BTCUSDT", MarkPrice: "67001.23456789", IndexPrice: "67003.11250000",
+			EstimatedSettlePrice: "66998.80112345", FundingRate: "0.00010000", NextFundingTime: 1718006400000,
+		}},
+		// A coinm book ticker carries the pair, and a later spot one must not.
+		{`{"stream":"btcusd_perp@bookTicker","data":{"e":"bookTicker","u":17,"s":"BTCUSD_PERP","ps":"BTCUSD","b":"67000.1","B":"120","a":"67000.2","A":"54","T":1718000000119,"E":1718000000123}}`,
+			"btcusd_perp@bookTicker", streamBookTicker, &BookTickerEvent{
+				EventType: "bookTicker", EventTime: 1718000000123, TransactionTime: 1718000000119, UpdateID: 17, Symbol: "BTCUSD_PERP", Pair: "BTCUSD",
+				BidPrice: "67000.1", BidQty: "120", AskPrice: "67000.2", AskQty: "54",
+			}},
+		{`{"stream":"btcusdt@bookTicker","data":{"u":18,"s":"BTCUSDT","b":"67000.10","B":"1","a":"67000.20","A":"2"}}`,
+			"btcusdt@bookTicker", streamBookTicker, &BookTickerEvent{UpdateID: 18, Symbol: "BTCUSDT", BidPrice: "67000.10", BidQty: "1", AskPrice: "67000.20", AskQty: "2"}},
+		// Data before the stream name is buffered and still decoded.
+		{`{"data":{"e":"kline","E":1718000060001,"s":"BTCUSDT","k":{"t":1718000000000,"T":1718000059999,"s":"BTCUSDT","i":"1m","o":"67000.00","c":"67012.34","h":"67020.00","l":"66990.00","x":true}},"stream":"btcusdt@kline_1m"}`,
+			"btcusdt@kline_1m", streamKline, &KlineEvent{
+				EventType: "kline", EventTime: 1718000060001, Symbol: "BTCUSDT",
+				Kline: KlinePayload{StartTime: 1718000000000, CloseTime: 1718000059999, Symbol: "BTCUSDT", Interval: "1m", Open: "67000.00", Close: "67012.34", High: "67020.00", Low: "66990.00", IsClosed: true},
+			}},
+	}
+
+	for _, tt := range tests {
+		stream, kind, event := decode(tt.frame)
+		if stream != tt.stream || kind != tt.kind {
+			t.Errorf("got stream %q kind %v, want %q and %v", stream, kind, tt.stream, tt.kind)
+		}
+		if !reflect.DeepEqual(event, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.stream, event, tt.want)
+		}
+	}
+
+	// Depth: the futures levels, then a spot book on the same decoder.
+	_, _, event := decode(benchFrames[3].frame)
+	depth := event.(*DepthEvent)
+	if depth.EventType != "depthUpdate" || depth.FirstUpdateID != 4812345678901 || depth.FinalUpdateID != 4812345679012 || depth.PrevUpdateID != 4812345678900 {
+		t.Errorf("futures depth header: %+v", depth)
+	}
+	if len(depth.FuturesBids) != 10 || depth.FuturesBids[0] != [2]string{"67000.10", "12.345"} || depth.FuturesAsks[9] != [2]string{"67001.10", "0.095"} {
+		t.Errorf("futures depth levels: bids %v, asks %v", depth.FuturesBids, depth.FuturesAsks)
+	}
+	if len(depth.Bids) != 0 || len(depth.Asks) != 0 {
+		t.Errorf("futures depth has spot levels: %v, %v", depth.Bids, depth.Asks)
+	}
+
+	_, _, event = decode(benchFrames[2].frame)
+	depth = event.(*DepthEvent)
+	if depth.LastUpdateID != 48123456789 || depth.EventType != "" || depth.FinalUpdateID != 0 {
+		t.Errorf("spot depth header: %+v", depth)
+	}
+	if len(depth.Bids) != 10 || depth.Bids[0] != [2]string{"67012.33000000", "1.20530000"} || depth.Asks[9] != [2]string{"67014.12000000", "0.02000000"} {
+		t.Errorf("spot depth levels: bids %v, asks %v", depth.Bids, depth.Asks)
+	}
+	if len(depth.FuturesBids) != 0 || len(depth.FuturesAsks) != 0 {
+		t.Errorf("spot depth kept the futures levels: %v, %v", depth.FuturesBids, depth.FuturesAsks)
+	}
+}
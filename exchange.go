@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// symbolInfo holds the exchange metadata the Trader needs for the traded
+// symbol, loaded once at startup.
+type symbolInfo struct {
+	status       string // TRADING when the symbol is open for orders
+	baseAsset    string
+	quoteAsset   string
+	marginAsset  string  // futures only
+	contractSize float64 // coinm only, in quote currency per contract
+	filters      symbolFilters
+}
+
+// symbolFilters are the exchange's order filters for the symbol. A zero
+// value means the exchange sets no such limit.
+type symbolFilters struct {
+	minQty, maxQty, stepSize                   float64 // LOT_SIZE
+	marketMinQty, marketMaxQty, marketStepSize float64 // MARKET_LOT_SIZE
+	minPrice, maxPrice, tickSize               float64 // PRICE_FILTER
+	minNotional                                float64 // NOTIONAL / MIN_NOTIONAL, spot and usdm
+}
+
+// loadSymbolInfo fetches exchange info for the configured pair.
+func (t *Trader) loadSymbolInfo() error {
+	info, err := fetchSymbolInfo(t.config.Market, t.config.Pair, t.spotClient, t.usdmClient, t.coinmClient)
+	if err != nil {
+		return err
+	}
+	if t.config.Market == "coinm" && info.contractSize <= 0 {
+		return fmt.Errorf("no contract size for %s", strings.ToUpper(t.config.Pair))
+	}
+	t.symbol = info
+	return nil
+}
+
+// fetchSymbolInfo looks pair up in the exchange info of market, using the
+// client for that market; the others may be nil. Exchange info is public,
+// so the clients need no API keys.
+func fetchSymbolInfo(market, pair string, spotClient *binance.Client, usdmClient *futures.Client, coinmClient *delivery.Client) (symbolInfo, error) {
+	symbol := strings.ToUpper(pair)
+	ctx := context.Background()
+
+	switch market {
+	case "spot":
+		info, err := spotClient.NewExchangeInfoService().Symbol(symbol).Do(ctx)
+		if err != nil {
+			return symbolInfo{}, err
+		}
+		for _, s := range info.Symbols {
+			if s.Symbol == symbol {
+				var f symbolFilters
+				if lot := s.LotSizeFilter(); lot != nil {
+					f.minQty, f.maxQty, f.stepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if lot := s.MarketLotSizeFilter(); lot != nil {
+					f.marketMinQty, f.marketMaxQty, f.marketStepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if pf := s.PriceFilter(); pf != nil {
+					f.minPrice, f.maxPrice, f.tickSize = filterValue(pf.MinPrice), filterValue(pf.MaxPrice), filterValue(pf.TickSize)
+				}
+				if nf := s.NotionalFilter(); nf != nil {
+					f.minNotional = filterValue(nf.MinNotional)
+				}
+				return symbolInfo{status: s.Status, baseAsset: s.BaseAsset, quoteAsset: s.QuoteAsset, filters: f}, nil
+			}
+		}
+	case "usdm":
+		info, err := usdmClient.NewExchangeInfoService().Do(ctx)
+		if err != nil {
+			return symbolInfo{}, err
+		}
+		for _, s := range info.Symbols {
+			if s.Symbol == symbol {
+				var f symbolFilters
+				if lot := s.LotSizeFilter(); lot != nil {
+					f.minQty, f.maxQty, f.stepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if lot := s.MarketLotSizeFilter(); lot != nil {
+					f.marketMinQty, f.marketMaxQty, f.marketStepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if pf := s.PriceFilter(); pf != nil {
+					f.minPrice, f.maxPrice, f.tickSize = filterValue(pf.MinPrice), filterValue(pf.MaxPrice), filterValue(pf.TickSize)
+				}
+				if nf := s.MinNotionalFilter(); nf != nil {
+					f.minNotional = filterValue(nf.Notional)
+				}
+				return symbolInfo{status: s.Status, baseAsset: s.BaseAsset, quoteAsset: s.QuoteAsset, marginAsset: s.MarginAsset, filters: f}, nil
+			}
+		}
+	case "coinm":
+		info, err := coinmClient.NewExchangeInfoService().Do(ctx)
+		if err != nil {
+			return symbolInfo{}, err
+		}
+		for _, s := range info.Symbols {
+			if s.Symbol == symbol {
+				var f symbolFilters
+				if lot := s.LotSizeFilter(); lot != nil {
+					f.minQty, f.maxQty, f.stepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if lot := s.MarketLotSizeFilter(); lot != nil {
+					f.marketMinQty, f.marketMaxQty, f.marketStepSize = filterValue(lot.MinQuantity), filterValue(lot.MaxQuantity), filterValue(lot.StepSize)
+				}
+				if pf := s.PriceFilter(); pf != nil {
+					f.minPrice, f.maxPrice, f.tickSize = filterValue(pf.MinPrice), filterValue(pf.MaxPrice), filterValue(pf.TickSize)
+				}
+				return symbolInfo{
+					status:       s.ContractStatus,
+					baseAsset:    s.BaseAsset,
+					quoteAsset:   s.QuoteAsset,
+					marginAsset:  s.MarginAsset,
+					contractSize: float64(s.ContractSize),
+					filters:      f,
+				}, nil
+			}
+		}
+	default:
+		return symbolInfo{}, fmt.Errorf("unsupported market type: %s", market)
+	}
+
+	return symbolInfo{}, fmt.Errorf("symbol %s not found in %s exchange info", symbol, market)
+}
+
+// filterValue parses a filter field; missing or malformed values read as 0,
+// meaning no limit.
+func filterValue(v string) float64 {
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
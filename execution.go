@@ -0,0 +1,667 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Execution algorithms, selected with ExecutionConfig.Entry and Exit.
+const (
+	algoMarket  = "market"  // one market order
+	algoLimit   = "limit"   // a limit order at the touch, chased as the book moves
+	algoTWAP    = "twap"    // equal market slices spread evenly over a duration
+	algoIceberg = "iceberg" // limit orders showing a slice of the size at a time, chased like limit
+	algoSplit   = "split"   // market orders sized to the book, for OnSlippage split
+)
+
+// What a limit or iceberg entry does with the unfilled rest when it times
+// out. Exits always fill the rest at market.
+const (
+	onTimeoutMarket  = "market"
+	onTimeoutAbandon = "abandon"
+)
+
+// What a market order does when its estimated slippage exceeds
+// MaxSlippageBps.
+const (
+	slippageReject = "reject" // not sent, unless it reduces the position
+	slippageReduce = "reduce" // cut to the quantity the book fills within the limit
+	slippageSplit  = "split"  // sent in pieces as the book refills
+)
+
+// splitTimeout is how long a split market order waits for the book to
+// refill before it gives up on the rest.
+const splitTimeout = time.Minute
+
+// chasePollInterval is how often a working limit order checks its status
+// and the book.
+const chasePollInterval = time.Second
+
+// ExecutionConfig controls how entries, ladder reductions and closes are
+// worked.
+type ExecutionConfig struct {
+	Entry           string  `json:"entry,omitempty"`             // market (default), limit, twap or iceberg
+	Exit            string  `json:"exit,omitempty"`              // reductions and closes: market (default), twap or iceberg
+	MinAlgoNotional float64 `json:"min_algo_notional,omitempty"` // orders smaller than this go at market whatever the algorithm
+
+	MaxSlippageBps float64 `json:"max_slippage_bps,omitempty"` // market orders: largest estimated slippage from the touch; 0 disables the guard
+	OnSlippage     string  `json:"on_slippage,omitempty"`      // market orders over max_slippage_bps: reject (default), reduce or split
+
+	PostOnly    bool    `json:"post_only,omitempty"`     // limit, iceberg: maker-only orders, never pay taker fees
+	MaxChaseBps float64 `json:"max_chase_bps,omitempty"` // limit, iceberg: how far orders may follow the book from the first price; 0 never re-prices
+	TimeoutSec  int     `json:"timeout_sec,omitempty"`   // limit, iceberg: how long to work the order, default 30
+	OnTimeout   string  `json:"on_timeout,omitempty"`    // limit, iceberg entries: market (default) fills the rest at market, abandon leaves it
+
+	TWAPSlices      int `json:"twap_slices,omitempty"`       // twap: number of market slices, default 5
+	TWAPDurationSec int `json:"twap_duration_sec,omitempty"` // twap: time from the first slice to the last, default 60
+
+	IcebergVisiblePct float64 `json:"iceberg_visible_pct,omitempty"` // iceberg: size of each visible order as a percentage of the whole, default 20
+}
+
+// uses reports whether entries or exits are worked with one of algos.
+func (c ExecutionConfig) uses(algos ...string) bool {
+	for _, a := range algos {
+		if c.Entry == a || c.Exit == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ExecutionConfig) applyDefaults() {
+	if c.Entry == "" {
+		c.Entry = algoMarket
+	}
+	if c.Exit == "" {
+		c.Exit = algoMarket
+	}
+	if c.MaxSlippageBps > 0 && c.OnSlippage == "" {
+		c.OnSlippage = slippageReject
+	}
+	if c.uses(algoLimit, algoIceberg) {
+		if c.TimeoutSec == 0 {
+			c.TimeoutSec = 30
+		}
+		if c.OnTimeout == "" {
+			c.OnTimeout = onTimeoutMarket
+		}
+	}
+	if c.uses(algoTWAP) {
+		if c.TWAPSlices == 0 {
+			c.TWAPSlices = 5
+		}
+		if c.TWAPDurationSec == 0 {
+			c.TWAPDurationSec = 60
+		}
+	}
+	if c.uses(algoIceberg) && c.IcebergVisiblePct == 0 {
+		c.IcebergVisiblePct = 20
+	}
+}
+
+func (c ExecutionConfig) validate() ConfigErrors {
+	var errs ConfigErrors
+	switch c.Entry {
+	case algoMarket, algoLimit, algoTWAP, algoIceberg:
+	default:
+		errs.add("invalid execution.entry: %q (market, limit, twap or iceberg)", c.Entry)
+	}
+	switch c.Exit {
+	case algoMarket, algoTWAP, algoIceberg:
+	default:
+		errs.add("invalid execution.exit: %q (market, twap or iceberg)", c.Exit)
+	}
+	if c.MinAlgoNotional < 0 {
+		errs.add("execution.min_algo_notional must not be negative")
+	}
+	if c.MaxSlippageBps < 0 {
+		errs.add("execution.max_slippage_bps must not be negative")
+	}
+	switch {
+	case c.MaxSlippageBps == 0 && c.OnSlippage != "":
+		errs.add("execution.on_slippage requires max_slippage_bps")
+	case c.MaxSlippageBps > 0 && c.OnSlippage != slippageReject && c.OnSlippage != slippageReduce && c.OnSlippage != slippageSplit:
+		errs.add("invalid execution.on_slippage: %q (reject, reduce or split)", c.OnSlippage)
+	}
+
+	if !c.uses(algoLimit, algoIceberg) {
+		if c.PostOnly || c.MaxChaseBps != 0 || c.TimeoutSec != 0 || c.OnTimeout != "" {
+			errs.add("execution.post_only, max_chase_bps, timeout_sec and on_timeout only apply to limit and iceberg execution")
+		}
+	} else {
+		if c.MaxChaseBps < 0 {
+			errs.add("execution.max_chase_bps must not be negative")
+		}
+		if c.TimeoutSec < 1 {
+			errs.add("execution.timeout_sec must be at least 1")
+		}
+		if c.OnTimeout != onTimeoutMarket && c.OnTimeout != onTimeoutAbandon {
+			errs.add("invalid execution.on_timeout: %q (market or abandon)", c.OnTimeout)
+		}
+	}
+
+	if !c.uses(algoTWAP) {
+		if c.TWAPSlices != 0 || c.TWAPDurationSec != 0 {
+			errs.add("execution.twap_slices and twap_duration_sec only apply to twap execution")
+		}
+	} else {
+		if c.TWAPSlices < 2 {
+			errs.add("execution.twap_slices must be at least 2")
+		}
+		if c.TWAPDurationSec < 1 {
+			errs.add("execution.twap_duration_sec must be at least 1")
+		}
+	}
+
+	if !c.uses(algoIceberg) {
+		if c.IcebergVisiblePct != 0 {
+			errs.add("execution.iceberg_visible_pct only applies to iceberg execution")
+		}
+	} else if c.IcebergVisiblePct <= 0 || c.IcebergVisiblePct > 100 {
+		errs.add("execution.iceberg_visible_pct must be above 0 and at most 100")
+	}
+	return errs
+}
+
+// parentOrder is one entry, reduction or close worked by an execution
+// algorithm as a series of child orders. A Trader works at most one at a
+// time; Status reports its progress and CancelParentOrder stops it.
+type parentOrder struct {
+	id       int64
+	algo     string
+	action   string
+	side     binance.SideType
+	long     bool
+	reducing bool
+	quantity float64
+	started  time.Time
+
+	filled    orderResult // the children's combined fills
+	children  int
+	cancelled string // why the parent was stopped early, empty while it runs
+}
+
+// ParentOrderStatus is the progress of a working parent order.
+type ParentOrderStatus struct {
+	ID       int64     `json:"id"`
+	Algo     string    `json:"algo"`
+	Action   string    `json:"action"`
+	Side     string    `json:"side"`
+	Quantity float64   `json:"quantity"`
+	Filled   float64   `json:"filled"`
+	Children int       `json:"children"`
+	Started  time.Time `json:"started"`
+}
+
+func (p *parentOrder) status() *ParentOrderStatus {
+	return &ParentOrderStatus{
+		ID:       p.id,
+		Algo:     p.algo,
+		Action:   p.action,
+		Side:     string(p.side),
+		Quantity: p.quantity,
+		Filled:   p.filled.executedQty,
+		Children: p.children,
+		Started:  p.started,
+	}
+}
+
+// settle folds a finished child order's fills into the parent.
+func (p *parentOrder) settle(res *orderResult, qty string, price float64) {
+	if res.executedQty <= 0 {
+		return
+	}
+	res.avgPrice = orderAvgPrice(res, price)
+	cost := p.filled.avgPrice*p.filled.executedQty + res.avgPrice*res.executedQty
+	p.filled.fills = append(p.filled.fills, orderFills(res, p.side, qty, res.avgPrice)...)
+	p.filled.executedQty += res.executedQty
+	p.filled.avgPrice = cost / p.filled.executedQty
+	p.filled.orderID = res.orderID
+}
+
+// placeEntry sends the order opening a position of quantity with the
+// configured entry execution. Anything but a market entry may fill only
+// part of quantity. Callers hold t.mu.
+func (t *Trader) placeEntry(side binance.SideType, long bool, quantity string) (*orderResult, error) {
+	return t.execute(t.config.Execution.Entry, actionEntry, side, long, false, quantity)
+}
+
+// placeExit sends the order reducing or closing the position by quantity
+// with the configured exit execution. Callers hold t.mu.
+func (t *Trader) placeExit(action string, side binance.SideType, quantity string) (*orderResult, error) {
+	return t.execute(t.config.Execution.Exit, action, side, t.isLong, true, quantity)
+}
+
+// filledEntry returns the price and notional actually entered with when an
+// entry for quantity was worked as a parent order or cut short, which may
+// have filled less than asked and away from the trigger price. A market
+// entry filled in full keeps the trigger price and sized notional.
+func (t *Trader) filledEntry(res *orderResult, quantity string, price, notional float64) (float64, float64) {
+	if res.executedQty <= 0 || res.avgPrice <= 0 {
+		return price, notional
+	}
+	if _, partial := partialFill(res, quantity); res.children == 0 && !partial {
+		return price, notional
+	}
+	if t.config.Market == "coinm" {
+		return res.avgPrice, res.executedQty * t.symbol.contractSize
+	}
+	return res.avgPrice, res.executedQty * res.avgPrice
+}
+
+// partialFill reports whether res filled less than the quantity asked for,
+// and how much it filled.
+func partialFill(res *orderResult, quantity string) (float64, bool) {
+	q, _ := strconv.ParseFloat(quantity, 64)
+	return res.executedQty, res.executedQty > 0 && res.executedQty < q*(1-1e-9)
+}
+
+// execute works an order for quantity with algo as one parent order and
+// returns the children's combined fills. The parent may fill less than
+// quantity when it is cancelled, the trader is paused or halted, or a child
+// fails part way; it is only an error if nothing filled. Market orders,
+// orders below MinAlgoNotional and closes during a flatten are sent as one
+// market order.
+//
+// Callers hold t.mu. It is released while the parent waits between child
+// orders, so status, pause, cancel and flatten requests are served.
+func (t *Trader) execute(algo, action string, side binance.SideType, long, reducing bool, quantity string) (*orderResult, error) {
+	q, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quantity %q: %v", quantity, err)
+	}
+	symbol := strings.ToUpper(t.config.Pair)
+	cfg := t.config.Execution
+	market := orderParams{side: side, orderType: orderTypeMarket, quantity: quantity, positionLong: long, reducing: reducing}
+	if algo == algoMarket || t.flattening || t.orderNotional(market) < cfg.MinAlgoNotional {
+		if t.flattening || cfg.MaxSlippageBps <= 0 || !t.slippageExceeded(side, q) {
+			return t.submitOrder(market)
+		}
+		switch cfg.OnSlippage {
+		case slippageReduce:
+			fit := math.Min(t.roundLot(t.ds.MaxFillQuantity(t.config.Pair, side == binance.SideTypeBuy, cfg.MaxSlippageBps)), q)
+			if fit <= 0 {
+				return nil, fmt.Errorf("no quantity fills within max slippage %.2f bps", cfg.MaxSlippageBps)
+			}
+			traderLog.Warn("order reduced to stay within max slippage", "symbol", symbol, "quantity", quantity, "reduced", fit, "maxSlippageBps", cfg.MaxSlippageBps)
+			market.quantity = formatQuantity(fit)
+			return t.submitOrder(market)
+		case slippageSplit:
+			algo = algoSplit
+		default:
+			// submitOrder rejects it, or sends an exit regardless.
+			return t.submitOrder(market)
+		}
+	}
+
+	t.parentSeq++
+	p := &parentOrder{
+		id:       t.parentSeq,
+		algo:     algo,
+		action:   action,
+		side:     side,
+		long:     long,
+		reducing: reducing,
+		quantity: q,
+		started:  time.Now(),
+	}
+	if est, err := t.ds.EstimateFill(t.config.Pair, side == binance.SideTypeBuy, q); err == nil {
+		p.filled.estimate = &est
+	}
+	t.parent = p
+	defer func() { t.parent = nil }()
+
+	traderLog.Info("parent order started", "symbol", symbol, "parentId", p.id, "algo", algo, "action", action, "side", side, "quantity", quantity)
+
+	switch algo {
+	case algoTWAP:
+		err = t.runTWAP(p)
+	case algoSplit:
+		err = t.runSplit(p)
+	default:
+		err = t.runLimit(p)
+	}
+
+	args := []interface{}{"symbol", symbol, "parentId", p.id, "algo", algo, "action", action,
+		"quantity", quantity, "filled", p.filled.executedQty, "avgPrice", p.filled.avgPrice, "children", p.children,
+		"elapsed", time.Since(p.started).Round(time.Second)}
+	switch {
+	case err != nil:
+		traderLog.Error("parent order failed", append(args, "err", err)...)
+	case p.cancelled != "":
+		traderLog.Warn("parent order stopped", append(args, "reason", p.cancelled)...)
+	default:
+		traderLog.Info("parent order done", args...)
+	}
+
+	if p.filled.executedQty <= 0 {
+		if err == nil && p.cancelled != "" {
+			err = fmt.Errorf("parent order stopped: %s", p.cancelled)
+		} else if err == nil {
+			err = fmt.Errorf("parent order filled nothing")
+		}
+		return nil, err
+	}
+	res := p.filled
+	res.children = p.children
+	res.status = string(binance.OrderStatusTypeFilled)
+	if _, partial := partialFill(&res, quantity); partial {
+		res.status = string(binance.OrderStatusTypePartiallyFilled)
+	}
+	return &res, nil
+}
+
+// wait sleeps for d with t.mu released and reports whether the parent order
+// must stop: it was cancelled, or the trader was paused or halted meanwhile.
+func (t *Trader) wait(p *parentOrder, d time.Duration) bool {
+	t.mu.Unlock()
+	time.Sleep(d)
+	t.mu.Lock()
+
+	if p.cancelled == "" && (t.paused || t.risk.Halted()) {
+		p.cancelled = "trader paused or halted"
+	}
+	return p.cancelled != ""
+}
+
+// remaining returns the parent's unfilled quantity, in whole lots.
+func (t *Trader) remaining(p *parentOrder) float64 {
+	return t.roundLot(p.quantity - p.filled.executedQty)
+}
+
+// marketChild sends a market child order for qty.
+func (t *Trader) marketChild(p *parentOrder, qty float64) error {
+	quantity := formatQuantity(qty)
+	res, err := t.submitOrder(orderParams{side: p.side, orderType: orderTypeMarket, quantity: quantity, positionLong: p.long, reducing: p.reducing, parentID: p.id})
+	if err != nil {
+		return err
+	}
+	p.children++
+	p.settle(res, quantity, t.touchPrice(p.side))
+	return nil
+}
+
+// runTWAP splits the parent into TWAPSlices market orders sent at even
+// intervals over TWAPDurationSec. Slices are rounded down to whole lots and
+// the last one takes whatever is left.
+func (t *Trader) runTWAP(p *parentOrder) error {
+	cfg := t.config.Execution
+	interval := time.Duration(cfg.TWAPDurationSec) * time.Second / time.Duration(cfg.TWAPSlices-1)
+	slice := t.roundLot(p.quantity / float64(cfg.TWAPSlices))
+
+	for i := 0; i < cfg.TWAPSlices; i++ {
+		next := p.started.Add(time.Duration(i) * interval)
+		for wait := time.Until(next); wait > 0; wait = time.Until(next) {
+			if t.wait(p, min(wait, chasePollInterval)) {
+				return nil
+			}
+		}
+
+		qty := t.remaining(p)
+		if i < cfg.TWAPSlices-1 {
+			qty = math.Min(qty, slice)
+		}
+		if qty <= 0 {
+			continue
+		}
+		if err := t.marketChild(p, qty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSplit sends the parent as market orders no larger than the book fills
+// within MaxSlippageBps, waiting for the book to refill between them. The
+// rest is given up after splitTimeout.
+func (t *Trader) runSplit(p *parentOrder) error {
+	deadline := p.started.Add(splitTimeout)
+	for {
+		qty := math.Min(t.roundLot(t.ds.MaxFillQuantity(t.config.Pair, p.side == binance.SideTypeBuy, t.config.Execution.MaxSlippageBps)), t.remaining(p))
+		if qty > 0 {
+			if err := t.marketChild(p, qty); err != nil {
+				return err
+			}
+		}
+		if t.remaining(p) <= 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			p.cancelled = "book too thin within max slippage, rest abandoned"
+			return nil
+		}
+		if t.wait(p, chasePollInterval) {
+			return nil
+		}
+	}
+}
+
+// slippageExceeded reports whether a market order for quantity is estimated
+// to slip more than MaxSlippageBps, or more than the visible book holds.
+func (t *Trader) slippageExceeded(side binance.SideType, quantity float64) bool {
+	est, err := t.ds.EstimateFill(t.config.Pair, side == binance.SideTypeBuy, quantity)
+	return err != nil || !est.Complete() || est.SlippageBps > t.config.Execution.MaxSlippageBps
+}
+
+// checkSlippage estimates the fill of a market order and, with
+// MaxSlippageBps set, blocks it when the estimate exceeds the limit or
+// there is no book to estimate against. Reducing orders and flattens are
+// only estimated: a thin book must never keep us in a position. The
+// estimate is nil without a book.
+func (t *Trader) checkSlippage(p orderParams) (*FillEstimate, error) {
+	limit := t.config.Execution.MaxSlippageBps
+	if p.reducing || t.flattening {
+		limit = 0
+	}
+	quantity, _ := strconv.ParseFloat(p.quantity, 64)
+	est, err := t.ds.EstimateFill(t.config.Pair, p.side == binance.SideTypeBuy, quantity)
+	if err != nil {
+		if limit > 0 {
+			return nil, fmt.Errorf("cannot estimate slippage: %v", err)
+		}
+		return nil, nil
+	}
+	if limit > 0 && !est.Complete() {
+		return nil, fmt.Errorf("visible book holds %.8g of %s", est.Covered, p.quantity)
+	}
+	if limit > 0 && est.SlippageBps > limit {
+		return nil, fmt.Errorf("estimated slippage %.2f bps exceeds limit %.2f bps", est.SlippageBps, limit)
+	}
+	return &est, nil
+}
+
+// runLimit works the parent with limit orders at the best bid (buys) or ask
+// (sells). An iceberg shows IcebergVisiblePct of the size at a time and
+// posts the next slice as each one fills; a limit order shows it all.
+// Whenever the book moves away, the resting order is cancelled and the rest
+// re-posted at the new touch, never more than MaxChaseBps from the first
+// price. After TimeoutSec the rest is filled at market, or for entries with
+// OnTimeout abandon, left.
+func (t *Trader) runLimit(p *parentOrder) error {
+	cfg := t.config.Execution
+	symbol := strings.ToUpper(t.config.Pair)
+	deadline := p.started.Add(time.Duration(cfg.TimeoutSec) * time.Second)
+
+	visible := p.quantity
+	if p.algo == algoIceberg {
+		// A parent too small to slice shows all of it.
+		if slice := t.roundLot(p.quantity * cfg.IcebergVisiblePct / 100); slice > 0 {
+			visible = slice
+		}
+	}
+
+	var anchor float64     // the first price posted at, the base of the chase limit
+	var order *orderResult // the resting child order, nil when there is none
+	var orderPrice float64
+
+	// cancel takes down the resting order and settles what it filled.
+	cancel := func() {
+		if order == nil {
+			return
+		}
+		if err := t.cancelOrder(order.orderID); err != nil {
+			traderLog.Error("error cancelling child order", "symbol", symbol, "parentId", p.id, "orderId", order.orderID, "err", err)
+		}
+		if final, err := t.fetchOrder(order.orderID, p.side); err != nil {
+			traderLog.Error("error reading cancelled child order", "symbol", symbol, "parentId", p.id, "orderId", order.orderID, "err", err)
+		} else {
+			p.settle(final, formatQuantity(final.executedQty), orderPrice)
+		}
+		order = nil
+	}
+
+	for {
+		if t.remaining(p) <= 0 {
+			return nil
+		}
+
+		if order == nil {
+			price := t.chasePrice(p.side, anchor)
+			if price <= 0 {
+				return fmt.Errorf("no book price for a limit order")
+			}
+			qty := formatQuantity(math.Min(visible, t.remaining(p)))
+			res, err := t.submitOrder(orderParams{side: p.side, orderType: orderTypeLimit, quantity: qty, price: formatPrice(price), positionLong: p.long, reducing: p.reducing, postOnly: cfg.PostOnly, parentID: p.id})
+			switch {
+			case err != nil && cfg.PostOnly && wouldTake(err):
+				// The book moved through our price; re-post next round.
+			case err != nil:
+				cancel()
+				return err
+			case orderDone(res.status):
+				// Filled at once, or a post-only order expired.
+				p.children++
+				p.settle(res, qty, price)
+				if res.executedQty > 0 {
+					continue
+				}
+			default:
+				p.children++
+				order, orderPrice = res, price
+				traderLog.Info("child order posted", "symbol", symbol, "parentId", p.id, "side", p.side, "price", price, "quantity", qty, "orderId", res.orderID)
+			}
+			if anchor == 0 {
+				anchor = price
+			}
+		}
+
+		if t.wait(p, chasePollInterval) {
+			cancel()
+			return nil
+		}
+
+		if order != nil {
+			res, err := t.fetchOrder(order.orderID, p.side)
+			if err != nil {
+				traderLog.Warn("error checking child order", "symbol", symbol, "parentId", p.id, "orderId", order.orderID, "err", err)
+			} else if orderDone(res.status) {
+				p.settle(res, formatQuantity(res.executedQty), orderPrice)
+				order = nil
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			cancel()
+			break
+		}
+		if order != nil && t.chasePrice(p.side, anchor) != orderPrice {
+			cancel()
+		}
+	}
+
+	remaining := t.remaining(p)
+	if remaining <= 0 {
+		return nil
+	}
+	if !p.reducing && cfg.OnTimeout == onTimeoutAbandon {
+		p.cancelled = "timed out, rest abandoned"
+		return nil
+	}
+	traderLog.Info("parent order timed out, filling the rest at market", "symbol", symbol, "parentId", p.id, "quantity", remaining)
+	return t.marketChild(p, remaining)
+}
+
+// CancelParentOrder stops the working parent order, if there is one, and
+// reports whether there was. What it filled stands; the rest is not sent.
+func (t *Trader) CancelParentOrder() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.parent == nil {
+		return false
+	}
+	if t.parent.cancelled == "" {
+		t.parent.cancelled = "cancelled"
+	}
+	return true
+}
+
+// chasePrice returns the price to post a limit order at: the touch on our
+// side of the book, held within MaxChaseBps of anchor once there is one.
+func (t *Trader) chasePrice(side binance.SideType, anchor float64) float64 {
+	marketData := t.ds.GetMarketData(t.config.Pair)
+	if marketData == nil {
+		return 0
+	}
+	bid, ask := marketData.BestBidAsk()
+	tick := t.symbol.filters.tickSize
+
+	if side == binance.SideTypeBuy {
+		price := bid
+		if anchor > 0 {
+			price = math.Min(price, roundTick(anchor*(1+t.config.Execution.MaxChaseBps/10000), tick, math.Floor))
+		}
+		return price
+	}
+	price := ask
+	if anchor > 0 {
+		price = math.Max(price, roundTick(anchor*(1-t.config.Execution.MaxChaseBps/10000), tick, math.Ceil))
+	}
+	return price
+}
+
+func roundTick(price, tick float64, round func(float64) float64) float64 {
+	if tick <= 0 {
+		return price
+	}
+	return round(price/tick) * tick
+}
+
+// orderAvgPrice returns the order's average fill price, or price when the
+// exchange did not report one.
+func orderAvgPrice(res *orderResult, price float64) float64 {
+	if res.avgPrice > 0 {
+		return res.avgPrice
+	}
+	return price
+}
+
+// orderDone reports whether an order status is final.
+func orderDone(status string) bool {
+	switch status {
+	case "FILLED", "CANCELED", "EXPIRED", "EXPIRED_IN_MATCH", "REJECTED":
+		return true
+	}
+	return false
+}
+
+// wouldTake reports whether err is spot rejecting a LIMIT_MAKER order that
+// would have crossed the book. Futures expire such GTX orders instead.
+func wouldTake(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == -2010 && strings.Contains(apiErr.Message, "immediately match")
+}
+
+// formatPrice formats a price like a quantity: eight decimals at most,
+// without trailing zeros.
+func formatPrice(price float64) string {
+	return formatQuantity(price)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// Binance error codes returned when a setting already has the requested value.
+const (
+	errCodeNoNeedToChangeMarginType   = -4046
+	errCodeNoNeedToChangePositionSide = -4059
+)
+
+// futuresSettings is what the account reports for the traded symbol.
+type futuresSettings struct {
+	leverage   float64
+	marginType string // "ISOLATED" or "CROSSED"
+	hedgeMode  bool
+}
+
+// configureFutures applies the leverage, margin type and position mode from
+// the config, then reads them back and fails if the account disagrees.
+func (t *Trader) configureFutures() error {
+	symbol := strings.ToUpper(t.config.Pair)
+	hedge := t.config.PositionMode == "hedge"
+	ctx := context.Background()
+
+	var err error
+	switch t.config.Market {
+	case "usdm":
+		err = t.usdmClient.NewChangePositionModeService().DualSide(hedge).Do(ctx, t.usdmRecvWindow())
+		if err = ignoreAPIError(err, errCodeNoNeedToChangePositionSide); err != nil {
+			return fmt.Errorf("error setting position mode: %v", err)
+		}
+		err = t.usdmClient.NewChangeMarginTypeService().Symbol(symbol).MarginType(futures.MarginType(t.config.MarginType)).Do(ctx, t.usdmRecvWindow())
+		if err = ignoreAPIError(err, errCodeNoNeedToChangeMarginType); err != nil {
+			return fmt.Errorf("error setting margin type: %v", err)
+		}
+		_, err = t.usdmClient.NewChangeLeverageService().Symbol(symbol).Leverage(t.config.Leverage).Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return fmt.Errorf("error setting leverage: %v", err)
+		}
+	case "coinm":
+		err = t.coinmClient.NewChangePositionModeService().DualSide(hedge).Do(ctx, t.coinmRecvWindow())
+		if err = ignoreAPIError(err, errCodeNoNeedToChangePositionSide); err != nil {
+			return fmt.Errorf("error setting position mode: %v", err)
+		}
+		err = t.coinmClient.NewChangeMarginTypeService().Symbol(symbol).MarginType(delivery.MarginType(t.config.MarginType)).Do(ctx, t.coinmRecvWindow())
+		if err = ignoreAPIError(err, errCodeNoNeedToChangeMarginType); err != nil {
+			return fmt.Errorf("error setting margin type: %v", err)
+		}
+		_, err = t.coinmClient.NewChangeLeverageService().Symbol(symbol).Leverage(t.config.Leverage).Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return fmt.Errorf("error setting leverage: %v", err)
+		}
+	default:
+		return fmt.Errorf("futures settings are not available for market type: %s", t.config.Market)
+	}
+
+	settings, err := t.fetchFuturesSettings()
+	if err != nil {
+		return fmt.Errorf("error reading back futures settings: %v", err)
+	}
+	if settings.hedgeMode != hedge {
+		return fmt.Errorf("position mode is %s, expected %s", positionModeName(settings.hedgeMode), t.config.PositionMode)
+	}
+	if settings.marginType != t.config.MarginType {
+		return fmt.Errorf("margin type is %s, expected %s", settings.marginType, t.config.MarginType)
+	}
+	if settings.leverage != float64(t.config.Leverage) {
+		return fmt.Errorf("leverage is %.0fx, expected %dx", settings.leverage, t.config.Leverage)
+	}
+
+	t.leverage = settings.leverage
+	t.hedgeMode = settings.hedgeMode
+	exchangeLog.Info("futures settings applied", "symbol", symbol, "leverage", settings.leverage,
+		"marginType", settings.marginType, "positionMode", positionModeName(settings.hedgeMode))
+	return nil
+}
+
+// fetchFuturesSettings reads position mode, margin type and leverage for the
+// traded symbol from the exchange.
+func (t *Trader) fetchFuturesSettings() (*futuresSettings, error) {
+	symbol := strings.ToUpper(t.config.Pair)
+	ctx := context.Background()
+	settings := &futuresSettings{}
+
+	var leverage, marginType string
+	switch t.config.Market {
+	case "usdm":
+		mode, err := t.usdmClient.NewGetPositionModeService().Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		settings.hedgeMode = mode.DualSidePosition
+
+		positions, err := t.usdmClient.NewGetPositionRiskService().Symbol(symbol).Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range positions {
+			if p.Symbol == symbol {
+				leverage, marginType = p.Leverage, p.MarginType
+				break
+			}
+		}
+	case "coinm":
+		mode, err := t.coinmClient.NewGetPositionModeService().Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		settings.hedgeMode = mode.DualSidePosition
+
+		positions, err := t.coinmClient.NewGetPositionRiskService().Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range positions {
+			if p.Symbol == symbol {
+				leverage, marginType = p.Leverage, p.MarginType
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("futures settings are not available for market type: %s", t.config.Market)
+	}
+
+	if leverage == "" {
+		return nil, fmt.Errorf("no position information for %s", symbol)
+	}
+	value, err := strconv.ParseFloat(leverage, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leverage %q: %v", leverage, err)
+	}
+	settings.leverage = value
+
+	// Position risk reports "isolated" / "cross" rather than the enum used
+	// when changing the margin type.
+	switch strings.ToLower(marginType) {
+	case "isolated":
+		settings.marginType = "ISOLATED"
+	case "cross", "crossed":
+		settings.marginType = "CROSSED"
+	default:
+		return nil, fmt.Errorf("unknown margin type %q", marginType)
+	}
+
+	return settings, nil
+}
+
+// fundingPollInterval is how often funding payments are fetched on usdm.
+const fundingPollInterval = 5 * time.Minute
+
+// syncFunding books funding fees received or paid since the last poll into
+// the ledger. Only usdm exposes income history; callers hold t.mu.
+func (t *Trader) syncFunding() {
+	now := time.Now()
+	incomes, err := t.usdmClient.NewGetIncomeHistoryService().
+		Symbol(strings.ToUpper(t.config.Pair)).
+		IncomeType("FUNDING_FEE").
+		StartTime(t.fundingFrom.UnixMilli()).
+		EndTime(now.UnixMilli()).
+		Do(context.Background(), t.usdmRecvWindow())
+	if err != nil {
+		exchangeLog.Warn("error fetching funding payments", "err", err)
+		return
+	}
+
+	for _, income := range incomes {
+		amount, err := strconv.ParseFloat(income.Income, 64)
+		if err != nil {
+			exchangeLog.Warn("invalid funding amount", "amount", income.Income, "err", err)
+			continue
+		}
+		t.ledger.RecordFunding(amount)
+		t.risk.RecordRealized(amount)
+		traderLog.Info("funding payment", "symbol", income.Symbol, "amount", amount, "asset", income.Asset)
+	}
+	t.fundingFrom = now.Add(time.Millisecond)
+}
+
+// positionSide returns the positionSide to send with a futures order that
+// opens or reduces a position in the given direction. In one-way mode the
+// exchange expects BOTH.
+func (t *Trader) positionSide(isLong bool) string {
+	if !t.hedgeMode {
+		return "BOTH"
+	}
+	if isLong {
+		return "LONG"
+	}
+	return "SHORT"
+}
+
+func positionModeName(hedge bool) string {
+	if hedge {
+		return "hedge"
+	}
+	return "one_way"
+}
+
+// ignoreAPIError treats the given Binance error codes as success.
+func ignoreAPIError(err error, codes ...int64) error {
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		for _, code := range codes {
+			if apiErr.Code == code {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// estimateLiquidationPrice approximates the isolated-margin liquidation price
+// of a single position: the price at which the initial margin (1/leverage)
+// minus losses falls to the maintenance margin. It ignores fees, funding and
+// tiered maintenance brackets, so treat it as a guide rather than the
+// exchange's number.
+func estimateLiquidationPrice(entryPrice, leverage, maintenanceMarginRate float64, isLong bool) float64 {
+	if entryPrice <= 0 || leverage <= 0 {
+		return 0
+	}
+	if isLong {
+		return entryPrice * (1 - 1/leverage) / (1 - maintenanceMarginRate)
+	}
+	return entryPrice * (1 + 1/leverage) / (1 + maintenanceMarginRate)
+}
+
+// estimateInverseLiquidationPrice is estimateLiquidationPrice for inverse
+// (coinm) contracts, where margin and PnL are in the base coin: a long of
+// notional N at entry E holds N/(E*leverage) coin and loses N*(1/E - 1/P).
+func estimateInverseLiquidationPrice(entryPrice, leverage, maintenanceMarginRate float64, isLong bool) float64 {
+	if entryPrice <= 0 || leverage <= 0 {
+		return 0
+	}
+	if isLong {
+		return entryPrice * (1 + maintenanceMarginRate) / (1 + 1/leverage)
+	}
+	if leverage <= 1 {
+		return 0 // a 1x inverse short cannot be liquidated
+	}
+	return entryPrice * (1 - maintenanceMarginRate) / (1 - 1/leverage)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// runInitConfig implements the init-config subcommand: it builds a Config
+// from flags, prompting for anything missing when run in a terminal (or
+// for everything with -i), checks the pair against exchange info and writes
+// config/<pair>_<market>.json. It returns the process exit code.
+func runInitConfig(args []string) int {
+	fs := flag.NewFlagSet("init-config", flag.ExitOnError)
+	configDir := fs.String("config", "config", "Directory to write the config file to")
+	pair := fs.String("pair", "", "Trading pair, e.g. btcusdt")
+	market := fs.String("market", "", "Market: spot, usdm or coinm")
+	entrySignal := fs.String("entry-signal", "", "Entry signal: \"market\" or an entry price")
+	maxPosition := fs.Float64("max-position", 0, "Maximum position notional in quote currency")
+	testnet := fs.Bool("testnet", false, "Trade on the Binance testnet")
+	leverage := fs.Int("leverage", 0, "Futures leverage (default 1)")
+	interactive := fs.Bool("i", false, "Prompt for every setting")
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	skipSymbolCheck := fs.Bool("skip-symbol-check", false, "Do not check the pair against exchange info")
+	fs.Parse(args)
+
+	config := Config{
+		Pair:        strings.ToLower(*pair),
+		Market:      *market,
+		Exchange:    "binance",
+		EntrySignal: *entrySignal,
+		MaxPosition: *maxPosition,
+		UseTestnet:  *testnet,
+		Leverage:    *leverage,
+	}
+
+	missing := config.Pair == "" || config.Market == "" || config.EntrySignal == "" || config.MaxPosition == 0
+	if *interactive || missing {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintln(os.Stderr, "pair, market, entry-signal and max-position are required when not running in a terminal")
+			fs.Usage()
+			return 2
+		}
+		runConfigWizard(&config, *interactive)
+	}
+
+	config.applyDefaults()
+	if errs := config.validate(); len(errs) > 0 {
+		fmt.Fprintln(os.Stderr, color.RedString("Invalid config: %v", errs))
+		return 1
+	}
+
+	if !*skipSymbolCheck {
+		if err := checkSymbol(&config); err != nil {
+			fmt.Fprintln(os.Stderr, color.RedString("Symbol check failed: %v", err))
+			return 1
+		}
+	}
+
+	filename := filepath.Join(*configDir, fmt.Sprintf("%s_%s.json", config.Pair, config.Market))
+	if _, err := os.Stat(filename); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s already exists, use -force to overwrite\n", filename)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding config: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(*configDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating config directory: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+		return 1
+	}
+
+	// The file must load exactly like the bot will load it.
+	if _, err := loadConfig(filename); err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Written config does not load: %v", err))
+		return 1
+	}
+
+	fmt.Println(color.GreenString("Configuration file created: %s", filename))
+	return 0
+}
+
+// runConfigWizard prompts for the settings left empty, or for all of them
+// when all is set, showing current values as defaults.
+func runConfigWizard(config *Config, all bool) {
+	r := bufio.NewReader(os.Stdin)
+
+	if all || config.Pair == "" {
+		config.Pair = strings.ToLower(prompt(r, "Pair (e.g. btcusdt)", config.Pair))
+	}
+	if all || config.Market == "" {
+		for {
+			config.Market = prompt(r, "Market (spot, usdm, coinm)", config.Market)
+			if _, ok := BINANCE_WS_BASE_URL_MAP[config.Market]; ok {
+				break
+			}
+			fmt.Println("Market must be spot, usdm or coinm")
+		}
+	}
+	if all || config.EntrySignal == "" {
+		for {
+			config.EntrySignal = prompt(r, "Entry signal (\"market\" or a price)", config.EntrySignal)
+			if config.EntrySignal == "market" {
+				break
+			}
+			if price, err := strconv.ParseFloat(config.EntrySignal, 64); err == nil && price > 0 {
+				break
+			}
+			fmt.Println("Entry signal must be \"market\" or a positive price")
+		}
+	}
+	if all || config.MaxPosition == 0 {
+		config.MaxPosition = promptFloat(r, "Max position notional", config.MaxPosition)
+	}
+	if all {
+		config.UseTestnet = promptBool(r, "Use testnet", config.UseTestnet)
+		if config.Market != "spot" {
+			config.Leverage = int(promptFloat(r, "Leverage", float64(max(config.Leverage, 1))))
+		}
+	}
+}
+
+func prompt(r *bufio.Reader, label, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, _ := r.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+func promptFloat(r *bufio.Reader, label string, def float64) float64 {
+	defStr := ""
+	if def != 0 {
+		defStr = strconv.FormatFloat(def, 'f', -1, 64)
+	}
+	for {
+		v, err := strconv.ParseFloat(prompt(r, label, defStr), 64)
+		if err == nil && v > 0 {
+			return v
+		}
+		fmt.Println("Enter a positive number")
+	}
+}
+
+func promptBool(r *bufio.Reader, label string, def bool) bool {
+	defStr := "n"
+	if def {
+		defStr = "y"
+	}
+	for {
+		switch strings.ToLower(prompt(r, label+" (y/n)", defStr)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// checkSymbol verifies that the pair exists on the market and is trading.
+func checkSymbol(config *Config) error {
+	env := resolveEnvironment(config)
+	var spotClient *binance.Client
+	var usdmClient *futures.Client
+	var coinmClient *delivery.Client
+
+	switch config.Market {
+	case "spot":
+		spotClient = env.SpotClient("", "")
+	case "usdm":
+		usdmClient = env.USDMClient("", "")
+	case "coinm":
+		coinmClient = env.CoinMClient("", "")
+	}
+
+	info, err := fetchSymbolInfo(config.Market, config.Pair, spotClient, usdmClient, coinmClient)
+	if err != nil {
+		return err
+	}
+	if info.status != "TRADING" {
+		return fmt.Errorf("symbol %s is %s, not TRADING", strings.ToUpper(config.Pair), info.status)
+	}
+	return nil
+}
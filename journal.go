@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Journal actions.
+const (
+	actionEntry    = "entry"
+	actionReversal = "reversal"
+	actionReduce   = "reduce"
+	actionClose    = "close"
+)
+
+// JournalEntry is one trading action taken by a Trader.
+type JournalEntry struct {
+	Time        time.Time `json:"time" parquet:"time,timestamp(millisecond)"`
+	Symbol      string    `json:"symbol" parquet:"symbol,dict"`
+	Market      string    `json:"market" parquet:"market,dict"`
+	Action      string    `json:"action" parquet:"action,dict"`
+	Side        string    `json:"side" parquet:"side,dict"`
+	Quantity    float64   `json:"quantity" parquet:"quantity"`
+	FillPrice   float64   `json:"fill_price" parquet:"fill_price"`
+	Fees        float64   `json:"fees" parquet:"fees"`
+	StateBefore string    `json:"state_before" parquet:"state_before,dict"`
+	StateAfter  string    `json:"state_after" parquet:"state_after,dict"`
+	Reason      string    `json:"reason" parquet:"reason"`
+	RealizedPnL float64   `json:"realized_pnl" parquet:"realized_pnl"`
+
+	// Slippage from the touch when the order was decided, in basis points,
+	// positive when worse: estimated from the book and realized by the fills.
+	EstSlippageBps float64 `json:"est_slippage_bps" parquet:"est_slippage_bps"`
+	SlippageBps    float64 `json:"slippage_bps" parquet:"slippage_bps"`
+}
+
+var journalCSVHeader = []string{
+	"time", "symbol", "market", "action", "side", "quantity", "fill_price",
+	"fees", "state_before", "state_after", "reason", "realized_pnl",
+	"est_slippage_bps", "slippage_bps",
+}
+
+func (e JournalEntry) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		e.Time.UTC().Format(time.RFC3339Nano), e.Symbol, e.Market, e.Action, e.Side,
+		f(e.Quantity), f(e.FillPrice), f(e.Fees), e.StateBefore, e.StateAfter, e.Reason, f(e.RealizedPnL),
+		f(e.EstSlippageBps), f(e.SlippageBps),
+	}
+}
+
+type journalSink interface {
+	Write(e JournalEntry) error
+	Close() error
+}
+
+// Journal writes every JournalEntry to each configured format.
+type Journal struct {
+	mu    sync.Mutex
+	sinks []journalSink
+}
+
+// NewJournal opens journal files named basePath plus the format's extension.
+// Supported formats are csv, jsonl and parquet.
+func NewJournal(basePath string, formats []string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(basePath), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating journal directory: %v", err)
+	}
+
+	j := &Journal{}
+	for _, format := range formats {
+		var sink journalSink
+		var err error
+		switch strings.TrimSpace(format) {
+		case "":
+			continue
+		case "csv":
+			sink, err = newCSVSink(basePath + ".csv")
+		case "jsonl":
+			sink, err = newJSONLSink(basePath + ".jsonl")
+		case "parquet":
+			sink, err = newParquetSink(basePath + ".parquet")
+		default:
+			err = fmt.Errorf("unknown journal format: %s", format)
+		}
+		if err != nil {
+			j.Close()
+			return nil, err
+		}
+		j.sinks = append(j.sinks, sink)
+	}
+	return j, nil
+}
+
+// Record writes e to every sink. Failures are logged, never returned, so a
+// full disk cannot stop the Trader from managing its position.
+func (j *Journal) Record(e JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, sink := range j.sinks {
+		if err := sink.Write(e); err != nil {
+			traderLog.Error("error writing journal entry", "err", err)
+		}
+	}
+}
+
+func (j *Journal) Close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, sink := range j.sinks {
+		if err := sink.Close(); err != nil {
+			traderLog.Error("error closing journal", "err", err)
+		}
+	}
+	j.sinks = nil
+}
+
+type csvSink struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+// newCSVSink appends to the CSV journal at path. A journal written with
+// other columns, by an older version, is moved aside first, so no file
+// mixes rows of different widths.
+func newCSVSink(path string) (*csvSink, error) {
+	if err := rotateOldCSV(path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{file: file, w: csv.NewWriter(file)}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		s.w.Write(journalCSVHeader)
+		s.w.Flush()
+		if err := s.w.Error(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// rotateOldCSV renames the journal at path to path-<time>.csv when its
+// header is not journalCSVHeader.
+func rotateOldCSV(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	header, err := csv.NewReader(file).Read()
+	file.Close()
+	if err == io.EOF || slices.Equal(header, journalCSVHeader) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading CSV journal %s: %v", path, err)
+	}
+
+	old := strings.TrimSuffix(path, ".csv") + "-" + time.Now().Format("20060102-150405") + ".csv"
+	if err := os.Rename(path, old); err != nil {
+		return fmt.Errorf("error moving aside CSV journal %s with old columns: %v", path, err)
+	}
+	traderLog.Warn("CSV journal has old columns, starting a new file", "path", path, "old", old)
+	return nil
+}
+
+func (s *csvSink) Write(e JournalEntry) error {
+	s.w.Write(e.csvRecord())
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Close() error {
+	return s.file.Close()
+}
+
+type jsonlSink struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newJSONLSink(path string) (*jsonlSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *jsonlSink) Write(e JournalEntry) error {
+	return s.enc.Encode(e)
+}
+
+func (s *jsonlSink) Close() error {
+	return s.file.Close()
+}
+
+// parquetSink keeps all rows in memory and rewrites the file on every entry,
+// so the file on disk always has a valid footer even if the bot is killed.
+// A journal is a handful of rows per hour, which keeps this cheap.
+type parquetSink struct {
+	path string
+	rows []JournalEntry
+}
+
+func newParquetSink(path string) (*parquetSink, error) {
+	s := &parquetSink{path: path}
+
+	rows, err := parquet.ReadFile[JournalEntry](path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading parquet journal %s: %v", path, err)
+	}
+	s.rows = rows
+	return s, nil
+}
+
+func (s *parquetSink) Write(e JournalEntry) error {
+	s.rows = append(s.rows, e)
+
+	tmp := s.path + ".tmp"
+	if err := parquet.WriteFile(tmp, s.rows); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *parquetSink) Close() error {
+	return nil
+}
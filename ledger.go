@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fill is one execution of an order.
+type Fill struct {
+	Time            time.Time `json:"time"`
+	OrderID         int64     `json:"order_id"`
+	Side            string    `json:"side"`
+	Price           float64   `json:"price"`
+	Quantity        float64   `json:"quantity"`
+	Commission      float64   `json:"commission"`
+	CommissionAsset string    `json:"commission_asset"`
+	Fee             float64   `json:"fee"` // commission converted to quote currency
+}
+
+// PositionRecord is the ledger of a single position from entry to flat.
+// RealizedPnL is gross; fees and funding are tracked separately.
+type PositionRecord struct {
+	Symbol        string    `json:"symbol"`
+	Market        string    `json:"market"`
+	Long          bool      `json:"long"`
+	OpenedAt      time.Time `json:"opened_at"`
+	ClosedAt      time.Time `json:"closed_at,omitempty"`
+	Quantity      float64   `json:"quantity"`
+	AvgEntryPrice float64   `json:"avg_entry_price"`
+	RealizedPnL   float64   `json:"realized_pnl"`
+	Fees          float64   `json:"fees"`
+	Funding       float64   `json:"funding"`
+	Fills         []Fill    `json:"fills"`
+
+	// ContractSize is set for inverse (coinm) positions: Quantity and fill
+	// quantities are then contracts, each worth ContractSize in quote.
+	ContractSize float64 `json:"contract_size,omitempty"`
+}
+
+// pnl returns the gross PnL in quote currency of closing qty at price. An
+// inverse position earns qty*ContractSize*(1/entry - 1/price) in the base
+// coin on a long, valued here at price.
+func (p *PositionRecord) pnl(qty, price float64) float64 {
+	diff := price - p.AvgEntryPrice
+	if !p.Long {
+		diff = -diff
+	}
+	if p.ContractSize > 0 {
+		coin := qty * p.ContractSize * diff / (p.AvgEntryPrice * price)
+		return coin * price
+	}
+	return qty * diff
+}
+
+// NetPnL is realized PnL after fees and funding.
+func (p *PositionRecord) NetPnL() float64 {
+	return p.RealizedPnL - p.Fees + p.Funding
+}
+
+// PnLTotals accumulates PnL over a session or the lifetime of the ledger.
+type PnLTotals struct {
+	RealizedPnL float64 `json:"realized_pnl"`
+	Fees        float64 `json:"fees"`
+	Funding     float64 `json:"funding"`
+	Positions   int     `json:"positions"`
+}
+
+func (p PnLTotals) Net() float64 {
+	return p.RealizedPnL - p.Fees + p.Funding
+}
+
+// LedgerSummary is a point-in-time view of the ledger for status output.
+type LedgerSummary struct {
+	Open          *PositionRecord `json:"open,omitempty"`
+	UnrealizedPnL float64         `json:"unrealized_pnl"`
+	SessionStart  time.Time       `json:"session_start"`
+	Session       PnLTotals       `json:"session"`
+	Lifetime      PnLTotals       `json:"lifetime"`
+}
+
+// Ledger records every fill, fee and funding payment of a Trader and
+// computes realized and unrealized PnL in quote currency. Closed positions
+// and lifetime totals are persisted; session totals start at zero each run.
+type Ledger struct {
+	mu   sync.Mutex
+	path string
+
+	// ID is random, made when the ledger is created; it keeps client order
+	// IDs apart between ledgers on the same symbol.
+	ID string `json:"id"`
+
+	Open     *PositionRecord   `json:"open,omitempty"`
+	Closed   []*PositionRecord `json:"closed"`
+	Lifetime PnLTotals         `json:"lifetime"`
+
+	// Orders sent for position number StepPosition, numbering client order IDs.
+	StepPosition int `json:"step_position,omitempty"`
+	Steps        int `json:"steps,omitempty"`
+
+	sessionStart time.Time
+	session      PnLTotals
+}
+
+// LoadLedger opens the ledger persisted at path, or starts an empty one.
+func LoadLedger(path string) (*Ledger, error) {
+	l := &Ledger{path: path, sessionStart: time.Now()}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading ledger: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, l); err != nil {
+			return nil, fmt.Errorf("error parsing ledger %s: %v", path, err)
+		}
+	}
+
+	if l.ID == "" {
+		var b [5]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, fmt.Errorf("error creating ledger ID: %v", err)
+		}
+		l.ID = strconv.FormatUint(binary.BigEndian.Uint64(append(make([]byte, 3), b[:]...)), 36)
+		if err := l.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Trader state does not survive a restart, so a position left open by a
+	// previous run can no longer be tracked here; archive it as is.
+	if l.Open != nil {
+		traderLog.Warn("ledger has an open position from a previous run; archiving it, check the exchange",
+			"symbol", l.Open.Symbol, "quantity", l.Open.Quantity)
+		l.Closed = append(l.Closed, l.Open)
+		l.Open = nil
+		l.save()
+	}
+	return l, nil
+}
+
+// OpenPosition starts a new position record. contractSize is zero except
+// for inverse contracts.
+func (l *Ledger) OpenPosition(symbol, market string, long bool, contractSize float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Open = &PositionRecord{Symbol: symbol, Market: market, Long: long, OpenedAt: time.Now(), ContractSize: contractSize}
+	l.save()
+}
+
+// RecordFill applies a fill to the open position and returns its effect on
+// net realized PnL: the gross PnL of any reduction minus the fee.
+func (l *Ledger) RecordFill(f Fill) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := l.Open
+	if p == nil {
+		traderLog.Error("ledger: fill with no open position", "orderId", f.OrderID)
+		return 0
+	}
+
+	var pnl float64
+	increasing := (f.Side == "BUY") == p.Long
+	switch {
+	case increasing && p.ContractSize > 0:
+		// Contracts have a fixed quote value, so the inverse entry price
+		// is the harmonic mean: contracts over coin paid.
+		var coin float64
+		if p.Quantity > 0 {
+			coin = p.Quantity / p.AvgEntryPrice
+		}
+		coin += f.Quantity / f.Price
+		p.Quantity += f.Quantity
+		p.AvgEntryPrice = p.Quantity / coin
+	case increasing:
+		cost := p.AvgEntryPrice*p.Quantity + f.Price*f.Quantity
+		p.Quantity += f.Quantity
+		p.AvgEntryPrice = cost / p.Quantity
+	default:
+		qty := f.Quantity
+		if qty > p.Quantity {
+			qty = p.Quantity
+		}
+		pnl = p.pnl(qty, f.Price)
+		p.Quantity -= qty
+	}
+
+	p.Fills = append(p.Fills, f)
+	p.RealizedPnL += pnl
+	p.Fees += f.Fee
+	l.session.RealizedPnL += pnl
+	l.session.Fees += f.Fee
+	l.Lifetime.RealizedPnL += pnl
+	l.Lifetime.Fees += f.Fee
+	l.save()
+
+	return pnl - f.Fee
+}
+
+// RecordFunding books a funding payment (positive when received) against
+// the open position, if any, and the session.
+func (l *Ledger) RecordFunding(amount float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Open != nil {
+		l.Open.Funding += amount
+	}
+	l.session.Funding += amount
+	l.Lifetime.Funding += amount
+	l.save()
+}
+
+// NextOrderStep numbers a new order: the position it belongs to, counted
+// over the ledger's lifetime (the open position, or the next one to open),
+// and its step within that position. Both are persisted before they are
+// returned, so a restart never hands out the same pair twice; if the ledger
+// cannot be saved, no step is handed out.
+func (l *Ledger) NextOrderStep() (position, step int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prevPosition, prevSteps := l.StepPosition, l.Steps
+	position = len(l.Closed) + 1
+	if l.StepPosition != position {
+		l.StepPosition, l.Steps = position, 0
+	}
+	l.Steps++
+	if err := l.save(); err != nil {
+		l.StepPosition, l.Steps = prevPosition, prevSteps
+		return 0, 0, fmt.Errorf("error saving order step: %v", err)
+	}
+	return position, l.Steps, nil
+}
+
+// ClosePosition archives the open position and returns its net PnL.
+func (l *Ledger) ClosePosition() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := l.Open
+	if p == nil {
+		return 0
+	}
+	p.ClosedAt = time.Now()
+	l.Closed = append(l.Closed, p)
+	l.Open = nil
+	l.session.Positions++
+	l.Lifetime.Positions++
+	l.save()
+
+	return p.NetPnL()
+}
+
+// Unrealized returns the open position's PnL if closed at price.
+func (l *Ledger) Unrealized(price float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.unrealized(price)
+}
+
+func (l *Ledger) unrealized(price float64) float64 {
+	p := l.Open
+	if p == nil || p.Quantity <= 0 || price <= 0 {
+		return 0
+	}
+	return p.pnl(p.Quantity, price)
+}
+
+// Summary returns a copy of the open position and the PnL totals, with
+// unrealized PnL valued at price.
+func (l *Ledger) Summary(price float64) LedgerSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := LedgerSummary{
+		UnrealizedPnL: l.unrealized(price),
+		SessionStart:  l.sessionStart,
+		Session:       l.session,
+		Lifetime:      l.Lifetime,
+	}
+	if l.Open != nil {
+		open := *l.Open
+		open.Fills = append([]Fill(nil), l.Open.Fills...)
+		s.Open = &open
+	}
+	return s
+}
+
+// save writes the ledger to its path. Errors are logged as well as
+// returned, since most callers cannot do anything about them.
+func (l *Ledger) save() error {
+	data, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		traderLog.Error("error encoding ledger", "err", err)
+		return err
+	}
+	if err := writeFileAtomic(l.path, data); err != nil {
+		traderLog.Error("error writing ledger", "err", err)
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames
+// it into place, so a crash mid-write never leaves a truncated state file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
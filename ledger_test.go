@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNextOrderStepPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	l, err := LoadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := 1; want <= 3; want++ {
+		position, step, err := l.NextOrderStep()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if position != 1 || step != want {
+			t.Fatalf("got position %d step %d, want 1 and %d", position, step, want)
+		}
+	}
+
+	reloaded, err := LoadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, step, _ := reloaded.NextOrderStep(); step != 4 {
+		t.Errorf("after reload got step %d, want 4", step)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestNextOrderStepRefusesUnsaved(t *testing.T) {
+	dir := t.TempDir()
+	l, err := LoadLedger(filepath.Join(dir, "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.NextOrderStep(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way of the temporary file makes every save fail.
+	if err := os.Mkdir(l.path+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.NextOrderStep(); err == nil {
+		t.Fatal("got a step the ledger could not save")
+	}
+
+	os.Remove(l.path + ".tmp")
+	if _, step, err := l.NextOrderStep(); err != nil || step != 2 {
+		t.Errorf("after the failure got step %d, %v; want 2", step, err)
+	}
+}
+
+func TestLedgerIDSeparatesClientOrderIDs(t *testing.T) {
+	config := &Config{Market: "spot", Pair: "btcusdt"}
+	a, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == "" || a.ID == b.ID {
+		t.Fatalf("ledger IDs %q and %q, want two different ones", a.ID, b.ID)
+	}
+	if NewOrderManager(config, a).strategy == NewOrderManager(config, b).strategy {
+		t.Error("two ledgers on one symbol share a client order ID prefix")
+	}
+
+	reloaded, err := LoadLedger(a.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.ID != a.ID {
+		t.Errorf("reloaded ledger ID %q, want %q", reloaded.ID, a.ID)
+	}
+}
+
+func TestLedgerPnL(t *testing.T) {
+	type fill struct {
+		side            string
+		price, quantity float64
+		fee             float64
+	}
+	tests := []struct {
+		name         string
+		long         bool
+		contractSize float64
+		fills        []fill
+		wantEntry    float64 // average entry price after the fills
+		wantRealized float64 // gross
+		wantNet      float64 // realized after fees
+		mark         float64
+		wantOpen     float64 // unrealized PnL of what is left, at mark
+	}{
+		{
+			name:      "long averaged up, half sold",
+			long:      true,
+			fills:     []fill{{"BUY", 100, 1, 0.1}, {"BUY", 200, 1, 0.2}, {"SELL", 180, 1, 0.18}},
+			wantEntry: 150, wantRealized: 30, wantNet: 29.52,
+			mark: 160, wantOpen: 10,
+		},
+		{
+			name:      "short covered at a profit",
+			fills:     []fill{{"SELL", 100, 2, 0}, {"BUY", 90, 1, 0}},
+			wantEntry: 100, wantRealized: 10, wantNet: 10,
+			mark: 110, wantOpen: -10,
+		},
+		{
+			name:      "oversized close stops at flat",
+			long:      true,
+			fills:     []fill{{"BUY", 100, 1, 0}, {"SELL", 90, 3, 0}},
+			wantEntry: 100, wantRealized: -10, wantNet: -10,
+			mark: 120, wantOpen: 0,
+		},
+		// Inverse positions: 100 USD contracts earn 1000*(1/entry - 1/exit)
+		// BTC per 10 contracts, valued at the exit price.
+		{
+			name:         "inverse long",
+			long:         true,
+			contractSize: 100,
+			fills:        []fill{{"BUY", 20000, 20, 0}, {"SELL", 25000, 10, 0}},
+			wantEntry:    20000, wantRealized: 0.01 * 25000, wantNet: 250,
+			mark: 16000, wantOpen: -0.0125 * 16000,
+		},
+		{
+			name:         "inverse short",
+			contractSize: 100,
+			fills:        []fill{{"SELL", 20000, 20, 0}, {"BUY", 16000, 10, 0}},
+			wantEntry:    20000, wantRealized: 0.0125 * 16000, wantNet: 200,
+			mark: 25000, wantOpen: -0.01 * 25000,
+		},
+		{
+			// 10 contracts at 20000 and 10 at 25000 cost 0.0009 BTC in
+			// contracts over price, so the entry is 20/0.0009, not 22500.
+			name:         "inverse entry is the harmonic mean",
+			long:         true,
+			contractSize: 100,
+			fills:        []fill{{"BUY", 20000, 10, 0}, {"BUY", 25000, 10, 0}, {"SELL", 25000, 10, 0}},
+			wantEntry:    20 / 0.0009, wantRealized: 0.005 * 25000, wantNet: 125,
+			mark: 25000, wantOpen: 125,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.OpenPosition("BTCUSDT", "spot", tt.long, tt.contractSize)
+			var net float64
+			for i, f := range tt.fills {
+				net += l.RecordFill(Fill{OrderID: int64(i + 1), Side: f.side, Price: f.price, Quantity: f.quantity, Fee: f.fee})
+			}
+
+			s := l.Summary(tt.mark)
+			if !approx(s.Open.AvgEntryPrice, tt.wantEntry) {
+				t.Errorf("average entry %v, want %v", s.Open.AvgEntryPrice, tt.wantEntry)
+			}
+			if !approx(s.Open.RealizedPnL, tt.wantRealized) || !approx(s.Session.RealizedPnL, tt.wantRealized) {
+				t.Errorf("realized %v (session %v), want %v", s.Open.RealizedPnL, s.Session.RealizedPnL, tt.wantRealized)
+			}
+			if !approx(net, tt.wantNet) || !approx(s.Open.NetPnL(), tt.wantNet) {
+				t.Errorf("net %v (position %v), want %v", net, s.Open.NetPnL(), tt.wantNet)
+			}
+			if !approx(s.UnrealizedPnL, tt.wantOpen) {
+				t.Errorf("unrealized at %v: %v, want %v", tt.mark, s.UnrealizedPnL, tt.wantOpen)
+			}
+
+			if got := l.ClosePosition(); !approx(got, tt.wantNet) {
+				t.Errorf("closed with net %v, want %v", got, tt.wantNet)
+			}
+			if l.Lifetime.Positions != 1 || !approx(l.Lifetime.Net(), tt.wantNet) {
+				t.Errorf("lifetime %+v, want one position netting %v", l.Lifetime, tt.wantNet)
+			}
+		})
+	}
+}
+
+func TestLedgerFunding(t *testing.T) {
+	l, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.OpenPosition("BTCUSDT", "usdm", true, 0)
+	l.RecordFill(Fill{Side: "BUY", Price: 100, Quantity: 1, Fee: 0.05})
+	l.RecordFunding(-0.5)
+	l.RecordFunding(0.2)
+	l.RecordFill(Fill{Side: "SELL", Price: 110, Quantity: 1, Fee: 0.05})
+
+	if got := l.ClosePosition(); !approx(got, 10-0.1-0.3) {
+		t.Errorf("net %v, want 9.6", got)
+	}
+	if !approx(l.Lifetime.Funding, -0.3) {
+		t.Errorf("lifetime funding %v, want -0.3", l.Lifetime.Funding)
+	}
+}
+
+// approx reports whether two amounts agree to within a billionth.
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
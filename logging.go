@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Per-component loggers. They write through slog's default handler until
+// setupLogging replaces them with the configured one.
+var (
+	wsLog       = slog.Default().With("component", "ws")
+	dataLog     = slog.Default().With("component", "datastore")
+	traderLog   = slog.Default().With("component", "trader")
+	exchangeLog = slog.Default().With("component", "exchange")
+	riskLog     = slog.Default().With("component", "risk")
+	controlLog  = slog.Default().With("component", "control")
+	notifyLog   = slog.Default().With("component", "notify")
+)
+
+// logOutput is the writer the configured handler logs to. The TUI points it
+// at its log pane while it runs: the loggers above are read by every
+// goroutine, so they are set up once, before any is started, and only the
+// writer behind them changes.
+var logOutput = &logSwitch{w: os.Stderr}
+
+// logSwitch is an io.Writer whose destination can be changed while logs are
+// written.
+type logSwitch struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *logSwitch) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// Set sends further writes to w and returns the previous writer.
+func (s *logSwitch) Set(w io.Writer) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.w
+	s.w = w
+	return old
+}
+
+// setupLogging installs the default logger and the component loggers.
+// format is "text" (colored, for terminals) or "json"; level is one of
+// debug, info, warn or error.
+func setupLogging(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level: %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = newColorHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	wsLog = logger.With("component", "ws")
+	dataLog = logger.With("component", "datastore")
+	traderLog = logger.With("component", "trader")
+	exchangeLog = logger.With("component", "exchange")
+	riskLog = logger.With("component", "risk")
+	controlLog = logger.With("component", "control")
+	notifyLog = logger.With("component", "notify")
+	return nil
+}
+
+// fatal logs at error level and exits, replacing log.Fatalf.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// colorHandler is a slog.Handler writing one line per record in the form
+//
+//	2006/01/02 15:04:05 INFO  message key=value ...
+//
+// with the level, and the message of warnings and errors, colored.
+type colorHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []byte // preformatted attributes from WithAttrs
+	prefix string // open groups, "a.b."
+}
+
+func newColorHandler(w io.Writer, opts *slog.HandlerOptions) *colorHandler {
+	h := &colorHandler{mu: &sync.Mutex{}, w: w, level: slog.LevelInfo}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+func (h *colorHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *colorHandler) Handle(_ context.Context, r slog.Record) error {
+	var paint func(a ...interface{}) string
+	switch {
+	case r.Level >= slog.LevelError:
+		paint = color.New(color.FgRed).Sprint
+	case r.Level >= slog.LevelWarn:
+		paint = color.New(color.FgYellow).Sprint
+	case r.Level >= slog.LevelInfo:
+		paint = color.New(color.FgGreen).Sprint
+	default:
+		paint = color.New(color.FgHiBlack).Sprint
+	}
+
+	buf := make([]byte, 0, 256)
+	if !r.Time.IsZero() {
+		buf = r.Time.AppendFormat(buf, "2006/01/02 15:04:05")
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, paint(fmt.Sprintf("%-5s", r.Level.String()))...)
+	buf = append(buf, ' ')
+	if r.Level >= slog.LevelWarn {
+		buf = append(buf, paint(r.Message)...)
+	} else {
+		buf = append(buf, r.Message...)
+	}
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func appendAttr(buf []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		group := a.Value.Group()
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range group {
+			buf = appendAttr(buf, prefix, ga)
+		}
+		return buf
+	}
+
+	buf = append(buf, ' ')
+	buf = append(buf, color.New(color.Faint).Sprint(prefix+a.Key+"=")...)
+
+	var s string
+	if a.Value.Kind() == slog.KindTime {
+		s = a.Value.Time().Format(time.RFC3339)
+	} else {
+		s = a.Value.String()
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		s = strconv.Quote(s)
+	}
+	return append(buf, s...)
+}
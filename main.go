@@ -3,53 +3,55 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/joho/godotenv"
 )
 
-type Config struct {
-	Pair         string  `json:"pair"`
-	Market       string  `json:"market"`
-	Exchange     string  `json:"exchange"`
-	EntrySignal  string  `json:"entry_signal"`
-	MaxPosition  float64 `json:"max_position"`
-	UseTestnet   bool    `json:"use_testnet"`
-}
-
-var BINANCE_WS_BASE_URL_MAP = map[string]string{
-	"spot":  "stream.binance.com:9443",
-	"usdm":  "fstream.binance.com",
-	"coinm": "dstream.binance.com",
-}
-
-var BINANCE_TESTNET_WS_BASE_URL_MAP = map[string]string{
-	"spot":  "testnet.binance.vision",
-	"usdm":  "stream.binancefuture.com",
-	"coinm": "dstream.binancefuture.com",
-}
-
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "init-config":
+			os.Exit(runInitConfig(os.Args[2:]))
+		}
+	}
+
 	// Load .env file
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fatal("error loading .env file", "err", err)
 	}
 
 	configDir := flag.String("config", "config", "Directory containing JSON config files")
+	stateDir := flag.String("state", "state", "Directory for persisted state")
+	journalFormats := flag.String("journal", "csv,jsonl", "Comma-separated trade journal formats: csv, jsonl, parquet")
+	resetKillSwitch := flag.Bool("reset-kill-switch", false, "Clear a tripped kill switch and resume trading")
+	logFormat := flag.String("log-format", "text", "Log output: text (colored) or json")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	controlAddr := flag.String("control-addr", "", "Serve the control API on this address, e.g. 127.0.0.1:8081 (disabled if empty; requires CONTROL_TOKEN)")
+	tuiMode := flag.Bool("tui", false, "Show a full-screen terminal dashboard instead of log output")
+	dryRun := flag.Bool("dry-run", false, "Check and log orders without sending them, filling them at the book price; state goes to <state>/dry-run")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (disabled if empty)")
 	flag.Parse()
 
+	if err := setupLogging(logOutput, *logFormat, *logLevel); err != nil {
+		fatal("error configuring logging", "err", err)
+	}
+
 	files, err := filepath.Glob(filepath.Join(*configDir, "*.json"))
 	if err != nil {
-		log.Fatalf("Error reading config directory: %v", err)
+		fatal("error reading config directory", "dir", *configDir, "err", err)
 	}
 
 	fmt.Println("Available config files:")
@@ -61,21 +63,29 @@ func main() {
 	fmt.Print("Enter the number of the config file to use: ")
 	_, err = fmt.Scan(&selection)
 	if err != nil || selection < 1 || selection > len(files) {
-		log.Fatalf("Invalid selection")
+		fatal("invalid selection")
 	}
 
 	selectedFile := files[selection-1]
 	config, err := loadConfig(selectedFile)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("error loading config", "file", selectedFile, "err", err)
+	}
+
+	config.DryRun = *dryRun
+	if *dryRun {
+		// Keep simulated positions and PnL apart from the real ledger,
+		// journal and kill switch.
+		*stateDir = filepath.Join(*stateDir, "dry-run")
 	}
 
 	fmt.Printf("Loaded config: %+v\n", config)
+	resolveEnvironment(config).PrintBanner(config.Pair)
 
 	// Test API key validity
 	err = testAPIKeyValidity(config)
 	if err != nil {
-		log.Fatalf("API key validation failed: %v", err)
+		fatal("API key validation failed", "err", err)
 	}
 
 	// Ask for manual entry price input
@@ -88,7 +98,7 @@ func main() {
 		} else {
 			manualEntryPrice, err := strconv.ParseFloat(input, 64)
 			if err != nil {
-				log.Fatalf("Invalid entry price: %v", err)
+				fatal("invalid entry price", "input", input, "err", err)
 			}
 			config.EntrySignal = fmt.Sprintf("%f", manualEntryPrice)
 		}
@@ -100,31 +110,88 @@ func main() {
 	fmt.Scanln(&direction)
 	isBuy := direction == "long"
 
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	// Keep signed requests within recvWindow as the local clock drifts
+	go serverClock.Run(resolveEnvironment(config))
+
 	// Initialize DataStore
 	ds := NewDataStore()
 
 	// Initialize WebSocket connection
 	ws, err := NewWebSocket(config, ds)
 	if err != nil {
-		log.Fatalf("Error creating WebSocket: %v", err)
+		fatal("error creating WebSocket", "err", err)
 	}
 	defer ws.Close()
 
 	// Start WebSocket connection
 	err = ws.Connect()
 	if err != nil {
-		log.Fatalf("Error connecting to WebSocket: %v", err)
+		fatal("error connecting to WebSocket", "err", err)
 	}
 
+	// Initialize Notifier
+	notifier, err := NewNotifier(config.Notify)
+	if err != nil {
+		fatal("error creating notifier", "err", err)
+	}
+	defer notifier.Close()
+
+	// Initialize RiskManager
+	risk, err := NewRiskManager(config.Risk, *stateDir, notifier)
+	if err != nil {
+		fatal("error creating RiskManager", "err", err)
+	}
+	if *resetKillSwitch {
+		risk.Reset()
+	}
+
+	// Initialize Ledger
+	ledger, err := LoadLedger(filepath.Join(*stateDir, fmt.Sprintf("ledger_%s_%s.json", config.Market, strings.ToLower(config.Pair))))
+	if err != nil {
+		fatal("error loading ledger", "err", err)
+	}
+
+	// Initialize Journal
+	journal, err := NewJournal(filepath.Join(*stateDir, fmt.Sprintf("journal_%s_%s", config.Market, strings.ToLower(config.Pair))), strings.Split(*journalFormats, ","))
+	if err != nil {
+		fatal("error opening trade journal", "err", err)
+	}
+	defer journal.Close()
+
 	// Initialize Trader
-	trader, err := NewTrader(config, ds, ws, isBuy)
+	trader, err := NewTrader(config, ds, ws, risk, ledger, journal, notifier, isBuy)
 	if err != nil {
-		log.Fatalf("Error creating Trader: %v", err)
+		fatal("error creating Trader", "err", err)
+	}
+	risk.Register(trader)
+
+	if *controlAddr != "" {
+		control, err := NewControlServer(os.Getenv("CONTROL_TOKEN"), risk, []*Trader{trader})
+		if err != nil {
+			fatal("error creating control API", "err", err)
+		}
+		go control.ListenAndServe(*controlAddr)
 	}
 
 	// Start the trader
 	go trader.Run()
 
+	if *tuiMode {
+		logs := &logRing{}
+		stderr := logOutput.Set(logs)
+		err := NewTUI(trader, ds, logs).Run()
+		logOutput.Set(stderr)
+		if err != nil {
+			fatal("TUI error", "err", err)
+		}
+		slog.Info("TUI closed, exiting", "state", trader.Status().State)
+		return
+	}
+
 	// Keep the program running
 	select {}
 }
@@ -137,38 +204,22 @@ func testAPIKeyValidity(config *Config) error {
 		return fmt.Errorf("API_KEY and SECRET_KEY must be set in the environment")
 	}
 
+	env := resolveEnvironment(config)
+	if err := serverClock.Sync(env); err != nil {
+		return fmt.Errorf("error syncing server time: %v", err)
+	}
+
 	var err error
 	switch config.Market {
 	case "spot":
-		var client *binance.Client
-		if config.UseTestnet {
-			client = binance.NewClient(apiKey, secretKey)
-			client.BaseURL = "https://testnet.binance.vision"
-		} else {
-			client = binance.NewClient(apiKey, secretKey)
-		}
-		_, err = client.NewSetServerTimeService().Do(context.Background())
-		if err != nil {
-			return fmt.Errorf("error setting server time: %v", err)
-		}
-		_, err = client.NewGetAccountService().Do(context.Background())
+		client := env.SpotClient(apiKey, secretKey)
+		_, err = client.NewGetAccountService().Do(context.Background(), binance.WithRecvWindow(int64(config.RecvWindowMs)))
 	case "usdm":
-		var client *futures.Client
-		if config.UseTestnet {
-			client = futures.NewClient(apiKey, secretKey)
-			client.BaseURL = "https://testnet.binancefuture.com"
-		} else {
-			client = futures.NewClient(apiKey, secretKey)
-		}
-		_, err = client.NewSetServerTimeService().Do(context.Background())
-		if err != nil {
-			return fmt.Errorf("error setting server time: %v", err)
-		}
-		_, err = client.NewGetAccountService().Do(context.Background())
+		client := env.USDMClient(apiKey, secretKey)
+		_, err = client.NewGetAccountService().Do(context.Background(), futures.WithRecvWindow(int64(config.RecvWindowMs)))
 	case "coinm":
-		// Note: The go-binance library doesn't have a separate client for coin-margined futures.
-		// You may need to implement this separately or use a different library for coin-margined futures.
-		return fmt.Errorf("coin-margined futures not supported in this implementation")
+		client := env.CoinMClient(apiKey, secretKey)
+		_, err = client.NewGetAccountService().Do(context.Background(), delivery.WithRecvWindow(int64(config.RecvWindowMs)))
 	default:
 		return fmt.Errorf("unsupported market type: %s", config.Market)
 	}
@@ -177,37 +228,6 @@ func testAPIKeyValidity(config *Config) error {
 		return fmt.Errorf("API key validation failed: %v", err)
 	}
 
-	log.Println("API key validation successful")
+	exchangeLog.Info("API key validation successful", "market", config.Market)
 	return nil
 }
-
-func loadConfig(filename string) (*Config, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var config Config
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
-	if err != nil {
-		return nil, err
-	}
-
-	// Validate config
-	if config.Exchange != "binance" {
-		return nil, fmt.Errorf("only Binance exchange is supported")
-	}
-
-	if _, ok := BINANCE_WS_BASE_URL_MAP[config.Market]; !ok {
-		return nil, fmt.Errorf("invalid market type: %s", config.Market)
-	}
-
-	return &config, nil
-}
-
-func parseFloat(s string) float64 {
-	f, _ := strconv.ParseFloat(s, 64)
-	return f
-}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "simplestrat"
+
+var (
+	wsConnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ws_connects_total",
+		Help:      "WebSocket connections established.",
+	})
+	wsDisconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ws_disconnects_total",
+		Help:      "WebSocket connections lost to a read error.",
+	})
+	wsReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ws_reconnects_total",
+		Help:      "WebSocket connections re-established after a disconnect.",
+	})
+	wsMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ws_messages_total",
+		Help:      "WebSocket messages processed, by stream.",
+	}, []string{"stream"})
+	wsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ws_message_errors_total",
+		Help:      "WebSocket messages that failed to decode or process, by stage.",
+	}, []string{"stage"})
+
+	ordersSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orders_total",
+		Help:      "Orders sent to the exchange, by market, type and side.",
+	}, []string{"market", "type", "side"})
+	orderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "order_errors_total",
+		Help:      "Orders rejected by the exchange or failed in transit, by market and type.",
+	}, []string{"market", "type"})
+	orderRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "order_retries_total",
+		Help:      "Orders sent again after an error, by market and error class.",
+	}, []string{"market", "class"})
+	ordersBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orders_blocked_total",
+		Help:      "Orders blocked before reaching the exchange, by market and guard.",
+	}, []string{"market", "guard"})
+	orderLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "order_latency_seconds",
+		Help:      "Time to place an order and read its result, by market and type.",
+		Buckets:   []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"market", "type"})
+
+	restWeightUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rest_weight_used",
+		Help:      "REST request weight used in the current minute, as reported by Binance, by API.",
+	}, []string{"api"})
+	restThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rest_throttled_total",
+		Help:      "REST requests held or refused by the rate limiter, and backoffs started, by API and action.",
+	}, []string{"api", "action"})
+	clockOffsetSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "clock_offset_seconds",
+		Help:      "Local clock minus the exchange's server time at the last sync.",
+	})
+	clockSyncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "clock_sync_errors_total",
+		Help:      "Server time syncs that failed.",
+	})
+
+	traderState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "trader_state",
+		Help:      "Current TraderState: 0 Idle, 1 InitialEntry, 2 SecondaryEntry.",
+	}, []string{"symbol"})
+	positionNotional = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "position_notional",
+		Help:      "Open position notional in quote currency, negative when short.",
+	}, []string{"symbol"})
+	pnlUnrealized = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pnl_unrealized",
+		Help:      "Unrealized PnL of the open position.",
+	}, []string{"symbol"})
+	pnlSessionNet = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pnl_session_net",
+		Help:      "Realized PnL after fees and funding since startup.",
+	}, []string{"symbol"})
+	pnlLifetimeNet = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pnl_lifetime_net",
+		Help:      "Realized PnL after fees and funding over the whole ledger.",
+	}, []string{"symbol"})
+	killSwitch = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "kill_switch",
+		Help:      "1 while the risk manager's kill switch is set.",
+	})
+
+	streamAge = newDataAgeCollector()
+)
+
+func init() {
+	prometheus.MustRegister(
+		wsConnects, wsDisconnects, wsReconnects, wsMessages, wsErrors,
+		ordersSubmitted, orderErrors, orderRetries, ordersBlocked, orderLatency,
+		restWeightUsed, restThrottled, clockOffsetSeconds, clockSyncErrors,
+		traderState, positionNotional, pnlUnrealized, pnlSessionNet, pnlLifetimeNet, killSwitch,
+		streamAge,
+	)
+}
+
+// serveMetrics exposes /metrics on addr. It runs until the listener fails.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	slog.Info("serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server stopped", "err", err)
+	}
+}
+
+// dataAgeCollector reports the seconds since the last message on each
+// stream, computed at scrape time so a silent stream shows a growing age.
+type dataAgeCollector struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	desc *prometheus.Desc
+}
+
+func newDataAgeCollector() *dataAgeCollector {
+	return &dataAgeCollector{
+		last: make(map[string]time.Time),
+		desc: prometheus.NewDesc(metricsNamespace+"_stream_data_age_seconds",
+			"Seconds since the last message on a WebSocket stream.", []string{"stream"}, nil),
+	}
+}
+
+func (c *dataAgeCollector) Seen(stream string, at time.Time) {
+	c.mu.Lock()
+	c.last[stream] = at
+	c.mu.Unlock()
+}
+
+func (c *dataAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *dataAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for stream, at := range c.last {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(at).Seconds(), stream)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification events.
+const (
+	eventEntry      = "entry"
+	eventReversal   = "reversal"
+	eventReduce     = "reduce"
+	eventClose      = "close"
+	eventStopOut    = "stop_out"
+	eventOrderError = "order_error"
+	eventKillSwitch = "kill_switch"
+)
+
+var notifyEvents = map[string]bool{
+	eventEntry: true, eventReversal: true, eventReduce: true, eventClose: true,
+	eventStopOut: true, eventOrderError: true, eventKillSwitch: true,
+}
+
+// NotifyConfig selects where notifications go. Telegram and Slack
+// credentials come from the environment (TELEGRAM_BOT_TOKEN,
+// TELEGRAM_CHAT_ID, SLACK_WEBHOOK_URL) like the API keys.
+type NotifyConfig struct {
+	RatePerMinute int                `json:"rate_per_minute,omitempty"` // per sink, default 20
+	Sinks         []NotifySinkConfig `json:"sinks,omitempty"`
+}
+
+type NotifySinkConfig struct {
+	Type   string   `json:"type"`   // telegram, slack, webhook or log
+	URL    string   `json:"url"`    // webhook only
+	Events []string `json:"events"` // empty means every event
+}
+
+// String masks the URL, which may carry a token, leaving its scheme and
+// host, so printing a config never shows the secret.
+func (c NotifySinkConfig) String() string {
+	masked := ""
+	if c.URL != "" {
+		masked = "***"
+		if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+			masked = u.Scheme + "://" + u.Host + "/***"
+		}
+	}
+	return fmt.Sprintf("{Type:%s URL:%s Events:%v}", c.Type, masked, c.Events)
+}
+
+func (c NotifyConfig) validate() ConfigErrors {
+	var errs ConfigErrors
+	if c.RatePerMinute < 0 {
+		errs.add("notify.rate_per_minute must not be negative")
+	}
+	for i, s := range c.Sinks {
+		switch s.Type {
+		case "telegram", "slack", "log":
+		case "webhook":
+			if s.URL == "" {
+				errs.add("notify.sinks[%d]: webhook requires a url", i)
+			}
+		default:
+			errs.add("notify.sinks[%d]: invalid sink type %q (telegram, slack, webhook or log)", i, s.Type)
+		}
+		for _, e := range s.Events {
+			if !notifyEvents[e] {
+				errs.add("notify.sinks[%d]: invalid event %q", i, e)
+			}
+		}
+	}
+	return errs
+}
+
+// Notification is one trading event, sent as JSON to webhooks and as Text
+// to chat sinks.
+type Notification struct {
+	Time   time.Time              `json:"time"`
+	Event  string                 `json:"event"`
+	Symbol string                 `json:"symbol"`
+	Market string                 `json:"market,omitempty"`
+	Text   string                 `json:"text"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+type notifySink interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// notifyTarget is a sink with its event filter and rate limit.
+type notifyTarget struct {
+	name       string
+	sink       notifySink
+	events     map[string]bool // nil means every event
+	sent       []time.Time
+	suppressed int
+}
+
+const (
+	notifyQueueSize   = 100
+	notifySendTimeout = 10 * time.Second
+)
+
+// Notifier delivers Notifications to its sinks on a background goroutine,
+// so a slow or failing endpoint never blocks a Trader. Each sink sends at
+// most RatePerMinute notifications per minute; the rest are counted and
+// reported with the next one that goes through.
+type Notifier struct {
+	rate    int
+	targets []*notifyTarget
+	queue   chan Notification
+	done    chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+func NewNotifier(cfg NotifyConfig) (*Notifier, error) {
+	n := &Notifier{
+		rate:  cfg.RatePerMinute,
+		queue: make(chan Notification, notifyQueueSize),
+		done:  make(chan struct{}),
+	}
+	if n.rate == 0 {
+		n.rate = 20
+	}
+
+	client := &http.Client{Timeout: notifySendTimeout}
+	for _, sc := range cfg.Sinks {
+		var sink notifySink
+		switch sc.Type {
+		case "telegram":
+			token, chatID := os.Getenv("TELEGRAM_BOT_TOKEN"), os.Getenv("TELEGRAM_CHAT_ID")
+			if token == "" || chatID == "" {
+				return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set for the telegram sink")
+			}
+			sink = &telegramSink{client: client, token: token, chatID: chatID}
+		case "slack":
+			webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
+			if webhookURL == "" {
+				return nil, fmt.Errorf("SLACK_WEBHOOK_URL must be set for the slack sink")
+			}
+			sink = &slackSink{client: client, url: webhookURL}
+		case "webhook":
+			sink = &webhookSink{client: client, url: sc.URL}
+		case "log":
+			sink = logSink{}
+		default:
+			return nil, fmt.Errorf("invalid notify sink type: %s", sc.Type)
+		}
+
+		target := &notifyTarget{name: sc.Type, sink: sink}
+		if len(sc.Events) > 0 {
+			target.events = make(map[string]bool)
+			for _, e := range sc.Events {
+				target.events[e] = true
+			}
+		}
+		n.targets = append(n.targets, target)
+	}
+
+	go n.run()
+	return n, nil
+}
+
+// Notify queues a notification. It never blocks; if the queue is full the
+// notification is dropped.
+func (n *Notifier) Notify(note Notification) {
+	if len(n.targets) == 0 {
+		return
+	}
+	if note.Time.IsZero() {
+		note.Time = time.Now()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- note:
+	default:
+		n.dropped++
+	}
+}
+
+// Close stops accepting notifications and waits briefly for queued ones to
+// be sent.
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(notifySendTimeout):
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for note := range n.queue {
+		n.mu.Lock()
+		if n.dropped > 0 {
+			note.Text += fmt.Sprintf(" (%d notifications dropped, queue full)", n.dropped)
+			n.dropped = 0
+		}
+		n.mu.Unlock()
+
+		for _, t := range n.targets {
+			n.deliver(t, note)
+		}
+	}
+}
+
+func (n *Notifier) deliver(t *notifyTarget, note Notification) {
+	if t.events != nil && !t.events[note.Event] {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-time.Minute)
+	recent := t.sent[:0]
+	for _, ts := range t.sent {
+		if ts.After(cutoff) {
+			recent = append(recent, ts)
+		}
+	}
+	t.sent = recent
+	if len(t.sent) >= n.rate {
+		t.suppressed++
+		return
+	}
+	t.sent = append(t.sent, now)
+
+	if t.suppressed > 0 {
+		note.Text += fmt.Sprintf(" (%d earlier notifications rate limited)", t.suppressed)
+		t.suppressed = 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifySendTimeout)
+	defer cancel()
+	if err := t.sink.Send(ctx, note); err != nil {
+		notifyLog.Warn("error sending notification", "sink", t.name, "event", note.Event, "err", err)
+	}
+}
+
+type telegramSink struct {
+	client *http.Client
+	token  string
+	chatID string
+}
+
+func (s *telegramSink) Send(ctx context.Context, n Notification) error {
+	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", s.token)
+	// The URL contains the bot token.
+	return redactURL("telegram sendMessage", postJSON(ctx, s.client, endpoint, map[string]string{"chat_id": s.chatID, "text": n.Text}))
+}
+
+type slackSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *slackSink) Send(ctx context.Context, n Notification) error {
+	// The webhook URL is the secret.
+	return redactURL("slack webhook", postJSON(ctx, s.client, s.url, map[string]string{"text": n.Text}))
+}
+
+// webhookSink posts the whole Notification as JSON.
+type webhookSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *webhookSink) Send(ctx context.Context, n Notification) error {
+	// The URL may carry a token.
+	return redactURL("webhook", postJSON(ctx, s.client, s.url, n))
+}
+
+// logSink writes notifications to the log; a stand-in for the chat sinks
+// when testing a configuration.
+type logSink struct{}
+
+func (logSink) Send(_ context.Context, n Notification) error {
+	notifyLog.Info("notification", "event", n.Event, "symbol", n.Symbol, "text", n.Text)
+	return nil
+}
+
+// redactURL drops the URL that transport errors quote, so a secret in it
+// never reaches the logs, and names the request by op instead.
+func redactURL(op string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %v", op, urlErr.Err)
+	}
+	return err
+}
+
+func postJSON(ctx context.Context, client *http.Client, endpoint string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
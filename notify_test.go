@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPrintedConfigMasksSinkURLs(t *testing.T) {
+	config := &Config{Pair: "btcusdt", Notify: NotifyConfig{Sinks: []NotifySinkConfig{
+		{Type: "webhook", URL: "https://hooks.example.com/services/T000/B000/s3cr3t?token=abc"},
+		{Type: "webhook", URL: "not a url s3cr3t"},
+		{Type: "log"},
+	}}}
+
+	for _, verb := range []string{"%v", "%+v", "%s"} {
+		out := fmt.Sprintf(verb, config)
+		if strings.Contains(out, "s3cr3t") || strings.Contains(out, "token=abc") {
+			t.Errorf("%s prints the sink URL: %s", verb, out)
+		}
+		if !strings.Contains(out, "https://hooks.example.com/***") {
+			t.Errorf("%s hides the sink host: %s", verb, out)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// orderReconcileInterval is how often the tracked orders are checked
+// against the exchange's open orders.
+const orderReconcileInterval = 30 * time.Second
+
+// OpenOrder is an order resting on the book.
+type OpenOrder struct {
+	ClientOrderID string    `json:"client_order_id"`
+	OrderID       int64     `json:"order_id"`
+	Side          string    `json:"side"`
+	Type          string    `json:"type"`
+	Quantity      string    `json:"quantity"`
+	Price         string    `json:"price,omitempty"`
+	StopPrice     string    `json:"stop_price,omitempty"`
+	Created       time.Time `json:"created"`
+	Adopted       bool      `json:"adopted,omitempty"`   // found on the exchange, not placed by this run
+	ParentID      int64     `json:"parent_id,omitempty"` // the parent order this is a child of
+
+	params orderParams
+}
+
+// OrderManager tracks a Trader's open orders by client order ID. Orders are
+// added when submitOrder leaves them resting, removed once they are seen
+// filled or cancelled, and reconciled against the exchange on a schedule.
+// It is guarded by Trader.mu.
+type OrderManager struct {
+	strategy      string
+	orders        map[string]*OpenOrder
+	lastReconcile time.Time
+}
+
+// NewOrderManager returns an OrderManager whose client order IDs carry the
+// market and symbol and the ledger's ID. Two runs on the same symbol with
+// different state, or a wiped state directory, get different ledger IDs, so
+// they never hand out the same client order ID.
+func NewOrderManager(config *Config, ledger *Ledger) *OrderManager {
+	h := fnv.New32a()
+	h.Write([]byte(config.Market + "/" + strings.ToUpper(config.Pair)))
+	return &OrderManager{
+		strategy: "ss" + strconv.FormatUint(uint64(h.Sum32()), 36) + "-" + ledger.ID,
+		orders:   make(map[string]*OpenOrder),
+	}
+}
+
+// clientOrderID derives an order's newClientOrderId from the strategy (the
+// market and symbol, and the ledger's ID), the ledger's position number and
+// the order's step in that position, with "o" marking orders that open or
+// add and "x" orders that reduce: e.g. "ss1k3z9qf-8d2kq0b-12-x3". The same
+// order always carries the same ID, so a retry can ask the exchange whether
+// it was already placed. Binance accepts up to 36 characters of
+// [.A-Z:/a-z0-9_-].
+func (t *Trader) clientOrderID(reducing bool) (string, error) {
+	position, step, err := t.ledger.NextOrderStep()
+	if err != nil {
+		return "", err
+	}
+	kind := "o"
+	if reducing {
+		kind = "x"
+	}
+	return fmt.Sprintf("%s-%s-%s%s", t.orders.strategy, strconv.FormatInt(int64(position), 36), kind, strconv.FormatInt(int64(step), 36)), nil
+}
+
+func (m *OrderManager) track(o *OpenOrder) {
+	m.orders[o.ClientOrderID] = o
+}
+
+// forget stops tracking the order with orderID, if it is tracked.
+func (m *OrderManager) forget(orderID int64) {
+	for id, o := range m.orders {
+		if o.OrderID == orderID {
+			delete(m.orders, id)
+		}
+	}
+}
+
+// list returns the tracked orders, oldest first.
+func (m *OrderManager) list() []OpenOrder {
+	orders := make([]OpenOrder, 0, len(m.orders))
+	for _, o := range m.orders {
+		orders = append(orders, *o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Created.Before(orders[j].Created) })
+	return orders
+}
+
+// trackOrder starts tracking an order submitOrder left resting on the book.
+// Callers hold t.mu.
+func (t *Trader) trackOrder(p orderParams, res *orderResult) {
+	if orderDone(res.status) {
+		return
+	}
+	t.orders.track(&OpenOrder{
+		ClientOrderID: p.clientOrderID,
+		OrderID:       res.orderID,
+		Side:          string(p.side),
+		Type:          p.orderType,
+		Quantity:      p.quantity,
+		Price:         p.price,
+		StopPrice:     p.stopPrice,
+		Created:       time.Now(),
+		ParentID:      p.parentID,
+		params:        p,
+	})
+}
+
+// OpenOrders returns the orders the Trader is tracking, oldest first.
+func (t *Trader) OpenOrders() []OpenOrder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.orders.list()
+}
+
+// CancelOrder cancels the tracked order with clientOrderID.
+func (t *Trader) CancelOrder(clientOrderID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o, ok := t.orders.orders[clientOrderID]
+	if !ok {
+		return fmt.Errorf("no open order %q", clientOrderID)
+	}
+	if err := t.checkNotChild(o); err != nil {
+		return err
+	}
+	if err := t.cancelOrder(o.OrderID); err != nil {
+		return fmt.Errorf("error cancelling order %s: %v", clientOrderID, err)
+	}
+	traderLog.Info("cancelled order", "symbol", t.config.Pair, "clientOrderId", clientOrderID, "orderId", o.OrderID)
+	return nil
+}
+
+// errParentOrderWorking refuses a cancel-all while a parent order is worked.
+var errParentOrderWorking = errors.New("a parent order is being worked; cancel the parent order first")
+
+// checkNotChild refuses to touch a child of the working parent order: the
+// parent would re-post whatever was cancelled, leaving two orders on the
+// book. Callers hold t.mu.
+func (t *Trader) checkNotChild(o *OpenOrder) error {
+	if t.parent != nil && o.ParentID == t.parent.id {
+		return fmt.Errorf("order %s is a child of parent order %d; cancel the parent order instead", o.ClientOrderID, t.parent.id)
+	}
+	return nil
+}
+
+// CancelAllOrders cancels every open order on the traded symbol, tracked or
+// not. It is refused while a parent order is worked, for the reason given
+// at checkNotChild.
+func (t *Trader) CancelAllOrders() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.parent != nil {
+		return errParentOrderWorking
+	}
+
+	if err := t.cancelAllOrders(); err != nil {
+		return fmt.Errorf("error cancelling open orders: %v", err)
+	}
+	traderLog.Info("cancelled all open orders", "symbol", t.config.Pair)
+	return nil
+}
+
+// ReplaceOrder cancels the tracked order with clientOrderID and places the
+// same order again with a new quantity and price, either of which may be
+// left empty to keep it. price is the limit price of limit orders and the
+// trigger price of stop and take-profit orders. The exchange has no atomic
+// cancel-replace here, so an order that fills before the cancel lands is
+// not replaced; a partial fill is taken off the new quantity.
+func (t *Trader) ReplaceOrder(clientOrderID, quantity, price string) (OpenOrder, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o, ok := t.orders.orders[clientOrderID]
+	if !ok {
+		return OpenOrder{}, fmt.Errorf("no open order %q", clientOrderID)
+	}
+	if err := t.checkNotChild(o); err != nil {
+		return OpenOrder{}, err
+	}
+	p := o.params
+	if p.orderType == "" {
+		return OpenOrder{}, fmt.Errorf("order %s has type %s, which cannot be replaced", clientOrderID, o.Type)
+	}
+	if quantity != "" {
+		p.quantity = quantity
+	}
+	if price != "" {
+		if p.orderType == orderTypeLimit {
+			p.price = price
+		} else {
+			p.stopPrice = price
+		}
+	}
+	if err := t.checkFilters(p); err != nil {
+		return OpenOrder{}, err
+	}
+	// Number the replacement first: if that fails, the order stays.
+	id, err := t.clientOrderID(p.reducing)
+	if err != nil {
+		return OpenOrder{}, err
+	}
+	p.clientOrderID = id
+
+	if err := t.cancelOrder(o.OrderID); err != nil {
+		return OpenOrder{}, fmt.Errorf("error cancelling order %s: %v", clientOrderID, err)
+	}
+	final, err := t.fetchOrder(o.OrderID, p.side)
+	if err != nil {
+		return OpenOrder{}, fmt.Errorf("order %s cancelled, but its fills are unknown, not replaced: %v", clientOrderID, err)
+	}
+	if final.status == string(binance.OrderStatusTypeFilled) {
+		return OpenOrder{}, fmt.Errorf("order %s filled before it was cancelled, not replaced", clientOrderID)
+	}
+	if final.executedQty > 0 {
+		q, _ := strconv.ParseFloat(p.quantity, 64)
+		left := t.roundLot(q - final.executedQty)
+		if left <= 0 {
+			return OpenOrder{}, fmt.Errorf("order %s filled %.8g before it was cancelled, nothing left to replace", clientOrderID, final.executedQty)
+		}
+		p.quantity = formatQuantity(left)
+	}
+
+	res, err := t.submitOrder(p)
+	if err != nil {
+		return OpenOrder{}, fmt.Errorf("order %s cancelled, replacement failed: %v", clientOrderID, err)
+	}
+	traderLog.Info("replaced order", "symbol", t.config.Pair, "clientOrderId", clientOrderID, "newClientOrderId", p.clientOrderID,
+		"orderId", res.orderID, "quantity", p.quantity, "price", p.price, "stopPrice", p.stopPrice)
+	if replaced, ok := t.orders.orders[p.clientOrderID]; ok {
+		return *replaced, nil
+	}
+	return OpenOrder{ClientOrderID: p.clientOrderID, OrderID: res.orderID, Side: string(p.side), Type: p.orderType,
+		Quantity: p.quantity, Price: p.price, StopPrice: p.stopPrice, Created: time.Now()}, nil
+}
+
+// reconcileOrders brings the tracked orders in line with the exchange's
+// open orders: tracked orders no longer open are dropped, and open orders
+// we did not place, e.g. before a restart, are adopted so they can be
+// cancelled. Callers hold t.mu.
+func (t *Trader) reconcileOrders() {
+	t.orders.lastReconcile = time.Now()
+
+	open, err := t.listOpenOrders()
+	if err != nil {
+		traderLog.Warn("error listing open orders", "symbol", t.config.Pair, "err", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, o := range open {
+		seen[o.ClientOrderID] = true
+		if _, ok := t.orders.orders[o.ClientOrderID]; !ok {
+			traderLog.Warn("adopting untracked open order", "symbol", t.config.Pair, "clientOrderId", o.ClientOrderID,
+				"orderId", o.OrderID, "side", o.Side, "type", o.Type, "quantity", o.Quantity)
+			adopted := o
+			t.orders.track(&adopted)
+		}
+	}
+	for id, o := range t.orders.orders {
+		if !seen[id] {
+			traderLog.Info("tracked order is no longer open", "symbol", t.config.Pair, "clientOrderId", id, "orderId", o.OrderID)
+			delete(t.orders.orders, id)
+		}
+	}
+}
+
+// listOpenOrders returns the exchange's open orders on the traded symbol.
+func (t *Trader) listOpenOrders() ([]OpenOrder, error) {
+	symbol := strings.ToUpper(t.config.Pair)
+	ctx := context.Background()
+
+	var orders []OpenOrder
+	switch t.config.Market {
+	case "spot":
+		list, err := t.spotClient.NewListOpenOrdersService().Symbol(symbol).Do(ctx, t.spotRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range list {
+			orders = append(orders, t.adoptedOrder(o.ClientOrderID, o.OrderID, string(o.Side), string(o.Type), "",
+				o.OrigQuantity, o.Price, o.StopPrice, o.Time))
+		}
+	case "usdm":
+		list, err := t.usdmClient.NewListOpenOrdersService().Symbol(symbol).Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range list {
+			orders = append(orders, t.adoptedOrder(o.ClientOrderID, o.OrderID, string(o.Side), string(o.Type), string(o.PositionSide),
+				o.OrigQuantity, o.Price, o.StopPrice, o.Time))
+		}
+	case "coinm":
+		list, err := t.coinmClient.NewListOpenOrdersService().Symbol(symbol).Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range list {
+			orders = append(orders, t.adoptedOrder(o.ClientOrderID, o.OrderID, string(o.Side), string(o.Type), string(o.PositionSide),
+				o.OrigQuantity, o.Price, o.StopPrice, o.Time))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+	return orders, nil
+}
+
+// adoptedOrder builds an OpenOrder from an exchange listing. Order types the
+// Trader places itself get params, so they can be replaced.
+func (t *Trader) adoptedOrder(clientOrderID string, orderID int64, side, orderType, positionSide, quantity, price, stopPrice string, created int64) OpenOrder {
+	// Binance pads values with zeros and sends "0" for unset prices.
+	trimZero := func(v string) string {
+		f, _ := strconv.ParseFloat(v, 64)
+		if f == 0 {
+			return ""
+		}
+		return formatQuantity(f)
+	}
+	o := OpenOrder{
+		ClientOrderID: clientOrderID,
+		OrderID:       orderID,
+		Side:          side,
+		Type:          orderType,
+		Quantity:      trimZero(quantity),
+		Price:         trimZero(price),
+		StopPrice:     trimZero(stopPrice),
+		Created:       msToTime(created),
+		Adopted:       true,
+	}
+
+	p := orderParams{
+		side:          binance.SideType(side),
+		quantity:      o.Quantity,
+		clientOrderID: clientOrderID,
+		positionLong:  t.isLong,
+	}
+	switch positionSide {
+	case "LONG":
+		p.positionLong = true
+	case "SHORT":
+		p.positionLong = false
+	}
+	switch orderType {
+	case "LIMIT", "LIMIT_MAKER":
+		p.orderType, p.price, p.postOnly = orderTypeLimit, o.Price, orderType == "LIMIT_MAKER"
+	case "STOP_LOSS", "STOP":
+		p.orderType, p.stopPrice, p.reducing = orderTypeStopLoss, o.StopPrice, true
+	case "TAKE_PROFIT":
+		p.orderType, p.stopPrice, p.reducing = orderTypeTakeProfit, o.StopPrice, true
+	}
+	o.Type = p.orderType
+	if o.Type == "" {
+		o.Type = orderType
+	}
+	o.params = p
+	return o
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const errCodeUnknownOrder = -2011
+
+// Market-independent order types, mapped to each market's enum in submitOrder.
+const (
+	orderTypeMarket     = "MARKET"
+	orderTypeLimit      = "LIMIT"
+	orderTypeStopLoss   = "STOP_LOSS"
+	orderTypeTakeProfit = "TAKE_PROFIT"
+)
+
+// orderParams describes an order independently of the market it is sent to.
+type orderParams struct {
+	side      binance.SideType
+	orderType string
+	quantity  string
+	price     string
+	stopPrice string
+
+	// positionLong is the direction of the position the order opens or
+	// reduces; it selects positionSide on futures accounts in hedge mode.
+	positionLong bool
+	// reducing marks orders that only reduce or close a position; the risk
+	// manager never blocks them.
+	reducing bool
+	// postOnly makes a limit order maker-only: LIMIT_MAKER on spot, GTX on
+	// futures. The exchange rejects or expires it rather than let it take.
+	postOnly bool
+	// clientOrderID is sent as newClientOrderId; submitOrder assigns one
+	// when it is empty.
+	clientOrderID string
+	// parentID is the parent order a child order was placed for, 0 for
+	// orders of their own.
+	parentID int64
+}
+
+// orderResult is what the exchange reported for a submitted order. fills is
+// empty for orders that are resting on the book.
+type orderResult struct {
+	orderID     int64
+	status      string
+	executedQty float64
+	avgPrice    float64
+	fills       []Fill
+
+	estimate *FillEstimate // pre-trade estimate for market orders and parent orders
+	children int           // child orders combined into a parent order's result
+}
+
+// submitOrder sends an order to the configured market. Every order the
+// Trader places goes through here. Callers hold t.mu.
+func (t *Trader) submitOrder(p orderParams) (*orderResult, error) {
+	symbol := strings.ToUpper(t.config.Pair)
+	if p.clientOrderID == "" {
+		// An ID that was not persisted could be handed out again after a
+		// restart and match an older order, so the order is not sent.
+		id, err := t.clientOrderID(p.reducing)
+		if err != nil {
+			ordersBlocked.WithLabelValues(t.config.Market, "order_id").Inc()
+			return nil, err
+		}
+		p.clientOrderID = id
+	}
+
+	var estimate *FillEstimate
+	if p.orderType == orderTypeMarket {
+		// Exits are never held back by a wide spread: a refused stop or
+		// flatten leaves the position open with nothing managing it.
+		if !p.reducing && !t.flattening {
+			if err := t.checkSpread(); err != nil {
+				ordersBlocked.WithLabelValues(t.config.Market, "spread").Inc()
+				return nil, err
+			}
+		}
+		var err error
+		if estimate, err = t.checkSlippage(p); err != nil {
+			ordersBlocked.WithLabelValues(t.config.Market, "slippage").Inc()
+			return nil, err
+		}
+	}
+
+	if err := t.risk.CheckOrder(t.config.Pair, t.orderNotional(p), p.reducing); err != nil {
+		ordersBlocked.WithLabelValues(t.config.Market, "risk").Inc()
+		return nil, fmt.Errorf("rejected by risk manager: %v", err)
+	}
+
+	send := t.sendOrder
+	if t.config.DryRun {
+		send = t.dryRunOrder
+	}
+
+	start := time.Now()
+	res, err := t.sendWithRetry(send, symbol, p)
+	ordersSubmitted.WithLabelValues(t.config.Market, p.orderType, string(p.side)).Inc()
+	orderLatency.WithLabelValues(t.config.Market, p.orderType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		orderErrors.WithLabelValues(t.config.Market, p.orderType).Inc()
+		t.notifier.Notify(Notification{
+			Event:  eventOrderError,
+			Symbol: symbol,
+			Market: t.config.Market,
+			Text:   fmt.Sprintf("%s %s %s order for %s failed: %v", symbol, p.side, p.orderType, p.quantity, err),
+			Fields: map[string]interface{}{"side": string(p.side), "type": p.orderType, "quantity": p.quantity, "error": err.Error()},
+		})
+		return nil, err
+	}
+	res.estimate = estimate
+	t.trackOrder(p, res)
+	return res, nil
+}
+
+// sendOrder builds and places the order on the configured market's client.
+func (t *Trader) sendOrder(symbol string, p orderParams) (*orderResult, error) {
+	switch t.config.Market {
+	case "spot":
+		s := t.spotClient.NewCreateOrderService().
+			Symbol(symbol).
+			Side(p.side).
+			Quantity(p.quantity).
+			NewClientOrderID(p.clientOrderID)
+		switch p.orderType {
+		case orderTypeMarket:
+			s.Type(binance.OrderTypeMarket)
+		case orderTypeLimit:
+			if p.postOnly {
+				s.Type(binance.OrderTypeLimitMaker).Price(p.price)
+			} else {
+				s.Type(binance.OrderTypeLimit).TimeInForce(binance.TimeInForceTypeGTC).Price(p.price)
+			}
+		case orderTypeStopLoss:
+			s.Type(binance.OrderTypeStopLoss).StopPrice(p.stopPrice)
+		case orderTypeTakeProfit:
+			s.Type(binance.OrderTypeTakeProfit).StopPrice(p.stopPrice)
+		default:
+			return nil, fmt.Errorf("unsupported order type: %s", p.orderType)
+		}
+		res, err := s.NewOrderRespType(binance.NewOrderRespTypeFULL).Do(context.Background(), t.spotRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		return t.spotOrderResult(res), nil
+	case "usdm":
+		s := t.usdmClient.NewCreateOrderService().
+			Symbol(symbol).
+			Side(futures.SideType(p.side)).
+			PositionSide(futures.PositionSideType(t.positionSide(p.positionLong))).
+			Quantity(p.quantity).
+			NewClientOrderID(p.clientOrderID)
+		switch p.orderType {
+		case orderTypeMarket:
+			s.Type(futures.OrderTypeMarket)
+		case orderTypeLimit:
+			tif := futures.TimeInForceTypeGTC
+			if p.postOnly {
+				tif = futures.TimeInForceTypeGTX
+			}
+			s.Type(futures.OrderTypeLimit).TimeInForce(tif).Price(p.price)
+		case orderTypeStopLoss:
+			s.Type(futures.OrderTypeStop).StopPrice(p.stopPrice)
+		case orderTypeTakeProfit:
+			s.Type(futures.OrderTypeTakeProfit).StopPrice(p.stopPrice)
+		default:
+			return nil, fmt.Errorf("unsupported order type: %s", p.orderType)
+		}
+		res, err := s.NewOrderResponseType(futures.NewOrderRespTypeRESULT).Do(context.Background(), t.usdmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		return t.usdmOrderResult(res), nil
+	case "coinm":
+		s := t.coinmClient.NewCreateOrderService().
+			Symbol(symbol).
+			Side(delivery.SideType(p.side)).
+			PositionSide(delivery.PositionSideType(t.positionSide(p.positionLong))).
+			Quantity(p.quantity).
+			NewClientOrderID(p.clientOrderID)
+		switch p.orderType {
+		case orderTypeMarket:
+			s.Type(delivery.OrderTypeMarket)
+		case orderTypeLimit:
+			tif := delivery.TimeInForceTypeGTC
+			if p.postOnly {
+				tif = delivery.TimeInForceTypeGTX
+			}
+			s.Type(delivery.OrderTypeLimit).TimeInForce(tif).Price(p.price)
+		case orderTypeStopLoss:
+			s.Type(delivery.OrderTypeStop).StopPrice(p.stopPrice)
+		case orderTypeTakeProfit:
+			s.Type(delivery.OrderTypeTakeProfit).StopPrice(p.stopPrice)
+		default:
+			return nil, fmt.Errorf("unsupported order type: %s", p.orderType)
+		}
+		res, err := s.NewOrderResponseType(delivery.NewOrderRespTypeRESULT).Do(context.Background(), t.coinmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		return t.coinmOrderResult(res), nil
+	default:
+		return nil, fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+}
+
+func (t *Trader) spotOrderResult(res *binance.CreateOrderResponse) *orderResult {
+	result := &orderResult{orderID: res.OrderID, status: string(res.Status)}
+	result.executedQty, _ = strconv.ParseFloat(res.ExecutedQuantity, 64)
+	quoteQty, _ := strconv.ParseFloat(res.CummulativeQuoteQuantity, 64)
+	if result.executedQty > 0 {
+		result.avgPrice = quoteQty / result.executedQty
+	}
+
+	for _, f := range res.Fills {
+		price, _ := strconv.ParseFloat(f.Price, 64)
+		qty, _ := strconv.ParseFloat(f.Quantity, 64)
+		commission, _ := strconv.ParseFloat(f.Commission, 64)
+		result.fills = append(result.fills, Fill{
+			Time:            msToTime(res.TransactTime),
+			OrderID:         res.OrderID,
+			Side:            string(res.Side),
+			Price:           price,
+			Quantity:        qty,
+			Commission:      commission,
+			CommissionAsset: f.CommissionAsset,
+			Fee:             t.commissionInQuote(f.CommissionAsset, commission, price),
+		})
+	}
+	return result
+}
+
+// usdmOrderResult reads the fills of a filled order from the account trade
+// list, since futures order responses carry no commission.
+func (t *Trader) usdmOrderResult(res *futures.CreateOrderResponse) *orderResult {
+	result := &orderResult{orderID: res.OrderID, status: string(res.Status)}
+	result.executedQty, _ = strconv.ParseFloat(res.ExecutedQuantity, 64)
+	result.avgPrice, _ = strconv.ParseFloat(res.AvgPrice, 64)
+	if result.executedQty > 0 {
+		result.fills = t.usdmFills(res.Symbol, res.OrderID)
+	}
+	return result
+}
+
+// usdmFills returns the fills of an order from the account trade list, or
+// nil if they could not be fetched.
+func (t *Trader) usdmFills(symbol string, orderID int64) []Fill {
+	trades, err := t.usdmClient.NewListAccountTradeService().
+		Symbol(symbol).
+		OrderID(orderID).
+		Do(context.Background(), t.usdmRecvWindow())
+	if err != nil {
+		exchangeLog.Warn("could not fetch fills, fees unknown", "symbol", symbol, "orderId", orderID, "err", err)
+		return nil
+	}
+
+	var fills []Fill
+	for _, tr := range trades {
+		price, _ := strconv.ParseFloat(tr.Price, 64)
+		qty, _ := strconv.ParseFloat(tr.Quantity, 64)
+		commission, _ := strconv.ParseFloat(tr.Commission, 64)
+		fills = append(fills, Fill{
+			Time:            msToTime(tr.Time),
+			OrderID:         tr.OrderID,
+			Side:            string(tr.Side),
+			Price:           price,
+			Quantity:        qty,
+			Commission:      commission,
+			CommissionAsset: tr.CommissionAsset,
+			Fee:             t.commissionInQuote(tr.CommissionAsset, commission, price),
+		})
+	}
+	return fills
+}
+
+// spotFills returns the fills of an order from the account trade list, or
+// nil if they could not be fetched.
+func (t *Trader) spotFills(symbol string, orderID int64, side string) []Fill {
+	trades, err := t.spotClient.NewListTradesService().
+		Symbol(symbol).
+		OrderId(orderID).
+		Do(context.Background(), t.spotRecvWindow())
+	if err != nil {
+		exchangeLog.Warn("could not fetch fills, fees unknown", "symbol", symbol, "orderId", orderID, "err", err)
+		return nil
+	}
+
+	var fills []Fill
+	for _, tr := range trades {
+		price, _ := strconv.ParseFloat(tr.Price, 64)
+		qty, _ := strconv.ParseFloat(tr.Quantity, 64)
+		commission, _ := strconv.ParseFloat(tr.Commission, 64)
+		fills = append(fills, Fill{
+			Time:            msToTime(tr.Time),
+			OrderID:         tr.OrderID,
+			Side:            side,
+			Price:           price,
+			Quantity:        qty,
+			Commission:      commission,
+			CommissionAsset: tr.CommissionAsset,
+			Fee:             t.commissionInQuote(tr.CommissionAsset, commission, price),
+		})
+	}
+	return fills
+}
+
+// coinmOrderResult uses the order's average price; the delivery client has
+// no account trade endpoint, so commissions are not known here.
+func (t *Trader) coinmOrderResult(res *delivery.CreateOrderResponse) *orderResult {
+	result := &orderResult{orderID: res.OrderID, status: string(res.Status)}
+	result.executedQty, _ = strconv.ParseFloat(res.ExecutedQuantity, 64)
+	result.avgPrice, _ = strconv.ParseFloat(res.AvgPrice, 64)
+	return result
+}
+
+// fetchOrder returns an order's current status and, once anything has
+// executed, its fills (none on coinm, as for submitted orders).
+func (t *Trader) fetchOrder(orderID int64, side binance.SideType) (*orderResult, error) {
+	return t.queryOrder(orderID, "", side)
+}
+
+// fetchOrderByClientID is fetchOrder for an order known only by its client
+// order ID, e.g. one whose placement timed out.
+func (t *Trader) fetchOrderByClientID(clientOrderID string, side binance.SideType) (*orderResult, error) {
+	return t.queryOrder(0, clientOrderID, side)
+}
+
+// queryOrder looks an order up by orderID or, when that is 0, by
+// clientOrderID.
+func (t *Trader) queryOrder(orderID int64, clientOrderID string, side binance.SideType) (*orderResult, error) {
+	if t.config.DryRun {
+		return t.dryRunQuery(orderID, clientOrderID)
+	}
+	symbol := strings.ToUpper(t.config.Pair)
+	ctx := context.Background()
+	result := &orderResult{orderID: orderID}
+
+	switch t.config.Market {
+	case "spot":
+		s := t.spotClient.NewGetOrderService().Symbol(symbol)
+		if orderID != 0 {
+			s.OrderID(orderID)
+		} else {
+			s.OrigClientOrderID(clientOrderID)
+		}
+		order, err := s.Do(ctx, t.spotRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		orderID = order.OrderID
+		result.orderID = orderID
+		result.status = string(order.Status)
+		result.executedQty, _ = strconv.ParseFloat(order.ExecutedQuantity, 64)
+		quoteQty, _ := strconv.ParseFloat(order.CummulativeQuoteQuantity, 64)
+		if result.executedQty > 0 {
+			result.avgPrice = quoteQty / result.executedQty
+			result.fills = t.spotFills(symbol, orderID, string(side))
+		}
+	case "usdm":
+		s := t.usdmClient.NewGetOrderService().Symbol(symbol)
+		if orderID != 0 {
+			s.OrderID(orderID)
+		} else {
+			s.OrigClientOrderID(clientOrderID)
+		}
+		order, err := s.Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		orderID = order.OrderID
+		result.orderID = orderID
+		result.status = string(order.Status)
+		result.executedQty, _ = strconv.ParseFloat(order.ExecutedQuantity, 64)
+		result.avgPrice, _ = strconv.ParseFloat(order.AvgPrice, 64)
+		if result.executedQty > 0 {
+			result.fills = t.usdmFills(symbol, orderID)
+		}
+	case "coinm":
+		s := t.coinmClient.NewGetOrderService().Symbol(symbol)
+		if orderID != 0 {
+			s.OrderID(orderID)
+		} else {
+			s.OrigClientOrderID(clientOrderID)
+		}
+		order, err := s.Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return nil, err
+		}
+		orderID = order.OrderID
+		result.orderID = orderID
+		result.status = string(order.Status)
+		result.executedQty, _ = strconv.ParseFloat(order.ExecutedQuantity, 64)
+		result.avgPrice, _ = strconv.ParseFloat(order.AvgPrice, 64)
+	default:
+		return nil, fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+	if orderDone(result.status) {
+		t.orders.forget(orderID)
+	}
+	return result, nil
+}
+
+// cancelOrder cancels an open order. An order that has already filled or
+// been cancelled is not an error.
+func (t *Trader) cancelOrder(orderID int64) error {
+	if t.config.DryRun {
+		t.dryRunCancel(orderID)
+		return nil
+	}
+	symbol := strings.ToUpper(t.config.Pair)
+	ctx := context.Background()
+
+	var err error
+	switch t.config.Market {
+	case "spot":
+		_, err = t.spotClient.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx, t.spotRecvWindow())
+	case "usdm":
+		_, err = t.usdmClient.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx, t.usdmRecvWindow())
+	case "coinm":
+		_, err = t.coinmClient.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx, t.coinmRecvWindow())
+	default:
+		return fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+	if err = ignoreAPIError(err, errCodeUnknownOrder); err != nil {
+		return err
+	}
+	t.orders.forget(orderID)
+	return nil
+}
+
+// orderFills returns the fills of a market order, synthesizing one from the
+// executed quantity and average price (or, failing that, the requested
+// quantity at the reference price) when the exchange gave no fill detail.
+func orderFills(res *orderResult, side binance.SideType, quantity string, price float64) []Fill {
+	if len(res.fills) > 0 {
+		return res.fills
+	}
+
+	fill := Fill{Time: time.Now(), OrderID: res.orderID, Side: string(side), Quantity: res.executedQty, Price: res.avgPrice}
+	if fill.Quantity <= 0 || fill.Price <= 0 {
+		fill.Quantity, _ = strconv.ParseFloat(quantity, 64)
+		fill.Price = price
+	}
+	return []Fill{fill}
+}
+
+// commissionInQuote converts a commission to quote currency. Commissions
+// paid in BNB (the fee discount) or any other asset are valued at that
+// asset's current price against the quote.
+func (t *Trader) commissionInQuote(asset string, amount, fillPrice float64) float64 {
+	switch {
+	case amount == 0:
+		return 0
+	case asset == t.symbol.quoteAsset:
+		return amount
+	case asset == t.symbol.baseAsset:
+		return amount * fillPrice
+	}
+
+	price, err := t.assetPrice(asset)
+	if err != nil {
+		exchangeLog.Warn("could not value commission", "amount", amount, "asset", asset, "err", err)
+		return 0
+	}
+	return amount * price
+}
+
+// assetPrice returns the last price of asset in the quote currency, from
+// the traded market's ticker. Prices are cached for a minute.
+func (t *Trader) assetPrice(asset string) (float64, error) {
+	if cached, ok := t.assetPrices[asset]; ok && time.Since(cached.at) < time.Minute {
+		return cached.price, nil
+	}
+
+	symbol := asset + t.symbol.quoteAsset
+	ctx := context.Background()
+
+	var price string
+	switch t.config.Market {
+	case "spot":
+		prices, err := t.spotClient.NewListPricesService().Symbol(symbol).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(prices) > 0 {
+			price = prices[0].Price
+		}
+	case "usdm":
+		prices, err := t.usdmClient.NewListPricesService().Symbol(symbol).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(prices) > 0 {
+			price = prices[0].Price
+		}
+	default:
+		return 0, fmt.Errorf("no price lookup for %s on %s", asset, t.config.Market)
+	}
+
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no price for %s: %v", symbol, err)
+	}
+	if t.assetPrices == nil {
+		t.assetPrices = make(map[string]cachedPrice)
+	}
+	t.assetPrices[asset] = cachedPrice{price: value, at: time.Now()}
+	return value, nil
+}
+
+type cachedPrice struct {
+	price float64
+	at    time.Time
+}
+
+// orderNotional values an order in quote currency at its limit price, or at
+// the last trade price for market and stop orders. On coinm the quantity is
+// contracts of a fixed quote value.
+func (t *Trader) orderNotional(p orderParams) float64 {
+	quantity, _ := strconv.ParseFloat(p.quantity, 64)
+	if t.config.Market == "coinm" {
+		return quantity * t.symbol.contractSize
+	}
+	price, _ := strconv.ParseFloat(p.price, 64)
+	if price <= 0 {
+		if marketData := t.ds.GetMarketData(t.config.Pair); marketData != nil {
+			price = marketData.LastPrice()
+		}
+	}
+	return quantity * price
+}
+
+// cancelAllOrders cancels every open order on the traded symbol.
+func (t *Trader) cancelAllOrders() error {
+	symbol := strings.ToUpper(t.config.Pair)
+	ctx := context.Background()
+	if t.config.DryRun {
+		exchangeLog.Info("dry run: cancel all orders not sent", "symbol", symbol)
+		clear(t.dryRunBook)
+		clear(t.orders.orders)
+		return nil
+	}
+
+	var err error
+	switch t.config.Market {
+	case "spot":
+		_, err = t.spotClient.NewCancelOpenOrdersService().Symbol(symbol).Do(ctx, t.spotRecvWindow())
+		// Spot reports "Unknown order sent" when there is nothing to cancel.
+		err = ignoreAPIError(err, errCodeUnknownOrder)
+	case "usdm":
+		err = t.usdmClient.NewCancelAllOpenOrdersService().Symbol(symbol).Do(ctx, t.usdmRecvWindow())
+	case "coinm":
+		err = t.coinmClient.NewCancelAllOpenOrdersService().Symbol(symbol).Do(ctx, t.coinmRecvWindow())
+	default:
+		return fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+	if err != nil {
+		return err
+	}
+	clear(t.orders.orders)
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Binance REST limits by API: request weight per minute and order counts
+// per window. They are shared by every client on the IP (weight) or account
+// (orders), so one limiter serves all of ours.
+var restLimits = map[string]restLimit{
+	"api":  {weight: 6000, orders: map[time.Duration]int{10 * time.Second: 100, 24 * time.Hour: 200000}},
+	"sapi": {weight: 12000},
+	"fapi": {weight: 2400, orders: map[time.Duration]int{10 * time.Second: 300, time.Minute: 1200}},
+	"dapi": {weight: 2400, orders: map[time.Duration]int{time.Minute: 1200}},
+}
+
+type restLimit struct {
+	weight int
+	orders map[time.Duration]int
+}
+
+const (
+	// rateLimitHeadroom is the share of each limit we use, leaving the rest
+	// for other tools on the same IP or account.
+	rateLimitHeadroom = 0.9
+	// maxRateLimitWait is the longest a request is held for a window to
+	// reset; requests that would wait longer fail at once.
+	maxRateLimitWait = 5 * time.Second
+	// Backoff after a 429 or 418 that came without a Retry-After header.
+	defaultTooManyRequestsBackoff = time.Minute
+	defaultBannedBackoff          = 2 * time.Minute
+	// restTimeout bounds every REST request, including time held by the
+	// limiter. A request that times out leaves an order in doubt, which
+	// sendWithRetry resolves by looking it up.
+	restTimeout = 15 * time.Second
+)
+
+// restLimiter is the HTTP transport of every Binance REST client. It reads
+// the used weight and order counts Binance reports on each response, holds
+// or refuses requests that would take us past rateLimitHeadroom of a limit,
+// and stops sending to an API entirely after a 429 (rate limited) or 418
+// (IP banned) until the Retry-After time has passed.
+var restLimiter = newRateLimiter(http.DefaultTransport)
+
+// restHTTPClient is the HTTP client the Binance clients are built with.
+var restHTTPClient = &http.Client{Transport: restLimiter, Timeout: restTimeout}
+
+type rateLimiter struct {
+	next http.RoundTripper
+
+	mu   sync.Mutex
+	apis map[string]*apiUsage // by host and API, e.g. "api.binance.com/api"
+}
+
+type apiUsage struct {
+	weight       windowCount
+	orders       map[time.Duration]windowCount
+	blockedUntil time.Time
+	blockStatus  int
+}
+
+// windowCount is a usage count Binance reported at a time, valid until its
+// window ends.
+type windowCount struct {
+	count  int
+	window time.Duration
+	at     time.Time
+}
+
+func (c windowCount) current(now time.Time) int {
+	if c.window <= 0 || !now.Truncate(c.window).Equal(c.at.Truncate(c.window)) {
+		return 0
+	}
+	return c.count
+}
+
+func (c windowCount) reset() time.Time {
+	return c.at.Truncate(c.window).Add(c.window)
+}
+
+// rateLimitError is returned for requests the limiter refused to send.
+type rateLimitError struct {
+	api    string
+	until  time.Time
+	reason string
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s, not sent (clears in %s)", e.api, e.reason, time.Until(e.until).Round(time.Second))
+}
+
+func newRateLimiter(next http.RoundTripper) *rateLimiter {
+	return &rateLimiter{next: next, apis: make(map[string]*apiUsage)}
+}
+
+func (l *rateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	family := apiFamily(req.URL.Path)
+	key := req.URL.Host + "/" + family
+	order := isOrderRequest(req)
+
+	wait, err := l.admit(key, family, order)
+	if err != nil {
+		restThrottled.WithLabelValues(family, "rejected").Inc()
+		return nil, err
+	}
+	if wait > 0 {
+		restThrottled.WithLabelValues(family, "queued").Inc()
+		exchangeLog.Warn("near REST rate limit, holding request", "api", key, "wait", wait.Round(time.Millisecond))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	l.record(key, family, resp)
+	return resp, nil
+}
+
+// admit decides whether a request to key may be sent now, after waiting,
+// or not at all.
+func (l *rateLimiter) admit(key, family string, order bool) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	u := l.usage(key)
+	limit := restLimits[family]
+
+	var until time.Time
+	var reason string
+	if now.Before(u.blockedUntil) {
+		until, reason = u.blockedUntil, fmt.Sprintf("backing off after HTTP %d", u.blockStatus)
+	}
+	if limit.weight > 0 && float64(u.weight.current(now)) >= rateLimitHeadroom*float64(limit.weight) && u.weight.reset().After(until) {
+		until, reason = u.weight.reset(), fmt.Sprintf("request weight %d of %d used", u.weight.current(now), limit.weight)
+	}
+	if order {
+		for window, allowed := range limit.orders {
+			c := u.orders[window]
+			if float64(c.current(now)) >= rateLimitHeadroom*float64(allowed) && c.reset().After(until) {
+				until, reason = c.reset(), fmt.Sprintf("%d of %d orders per %s placed", c.current(now), allowed, window)
+			}
+		}
+	}
+
+	wait := until.Sub(now)
+	if wait <= 0 {
+		return 0, nil
+	}
+	if wait > maxRateLimitWait {
+		return 0, &rateLimitError{api: key, until: until, reason: reason}
+	}
+	return wait, nil
+}
+
+// record reads the usage headers of a response and starts a backoff on 429
+// and 418.
+func (l *rateLimiter) record(key, family string, resp *http.Response) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	u := l.usage(key)
+	for name, values := range resp.Header {
+		name = strings.ToUpper(name)
+		var window time.Duration
+		var ok bool
+		switch {
+		case strings.HasPrefix(name, "X-MBX-USED-WEIGHT-"):
+			if window, ok = parseRateInterval(strings.TrimPrefix(name, "X-MBX-USED-WEIGHT-")); ok && window == time.Minute {
+				count, _ := strconv.Atoi(values[0])
+				u.weight = windowCount{count: count, window: window, at: now}
+				restWeightUsed.WithLabelValues(family).Set(float64(count))
+			}
+		case strings.HasPrefix(name, "X-MBX-ORDER-COUNT-"):
+			if window, ok = parseRateInterval(strings.TrimPrefix(name, "X-MBX-ORDER-COUNT-")); ok {
+				count, _ := strconv.Atoi(values[0])
+				u.orders[window] = windowCount{count: count, window: window, at: now}
+			}
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusTeapot {
+		return
+	}
+	backoff := defaultTooManyRequestsBackoff
+	if resp.StatusCode == http.StatusTeapot {
+		backoff = defaultBannedBackoff
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		backoff = time.Duration(secs) * time.Second
+	}
+	u.blockedUntil, u.blockStatus = now.Add(backoff), resp.StatusCode
+	restThrottled.WithLabelValues(family, "backoff").Inc()
+	exchangeLog.Error("REST rate limit hit, backing off", "api", key, "status", resp.StatusCode, "backoff", backoff)
+}
+
+func (l *rateLimiter) usage(key string) *apiUsage {
+	u, ok := l.apis[key]
+	if !ok {
+		u = &apiUsage{orders: make(map[time.Duration]windowCount)}
+		l.apis[key] = u
+	}
+	return u
+}
+
+// apiFamily returns the first path segment, which selects the limits:
+// api (spot), sapi, fapi (usdm) or dapi (coinm).
+func apiFamily(path string) string {
+	family, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return family
+}
+
+// isOrderRequest reports whether req places or amends orders, which count
+// against the order limits.
+func isOrderRequest(req *http.Request) bool {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		return false
+	}
+	return strings.HasSuffix(req.URL.Path, "/order") || strings.HasSuffix(req.URL.Path, "/batchOrders")
+}
+
+// parseRateInterval parses a header interval suffix such as 1M or 10S.
+func parseRateInterval(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	unit := map[byte]time.Duration{'S': time.Second, 'M': time.Minute, 'H': time.Hour, 'D': 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRateLimiterWaitHonoursContext(t *testing.T) {
+	sent := false
+	l := newRateLimiter(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		sent = true
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil
+	}))
+	// A backoff short enough that the limiter holds the request.
+	u := l.usage("api.binance.com/api")
+	u.blockedUntil, u.blockStatus = time.Now().Add(2*time.Second), http.StatusTooManyRequests
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.binance.com/api/v3/account", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = l.RoundTrip(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("held the request %v after its context ended", elapsed)
+	}
+	if sent {
+		t.Error("request sent after its context ended")
+	}
+}
+
+func TestParseRateInterval(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"1M", time.Minute, true},
+		{"10S", 10 * time.Second, true},
+		{"1H", time.Hour, true},
+		{"1D", 24 * time.Hour, true},
+		{"0M", 0, false},
+		{"M", 0, false},
+		{"1W", 0, false},
+		{"XM", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRateInterval(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRateInterval(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRequestClassification(t *testing.T) {
+	tests := []struct {
+		method, path string
+		family       string
+		order        bool
+	}{
+		{http.MethodPost, "/api/v3/order", "api", true},
+		{http.MethodGet, "/api/v3/order", "api", false},
+		{http.MethodDelete, "/fapi/v1/order", "fapi", false},
+		{http.MethodPut, "/fapi/v1/order", "fapi", true},
+		{http.MethodPost, "/fapi/v1/batchOrders", "fapi", true},
+		{http.MethodPost, "/dapi/v1/order", "dapi", true},
+		{http.MethodGet, "/sapi/v1/capital/config/getall", "sapi", false},
+		{http.MethodPost, "/api/v3/order/test", "api", false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "https://api.binance.com"+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := apiFamily(req.URL.Path); got != tt.family {
+			t.Errorf("apiFamily(%q) = %q, want %q", tt.path, got, tt.family)
+		}
+		if got := isOrderRequest(req); got != tt.order {
+			t.Errorf("isOrderRequest(%s %s) = %v, want %v", tt.method, tt.path, got, tt.order)
+		}
+	}
+}
+
+func TestRateLimiterAdmit(t *testing.T) {
+	const key = "fapi.binance.com/fapi"
+	tests := []struct {
+		name     string
+		status   int
+		headers  map[string]string
+		order    bool
+		wantWait bool   // held, then sent
+		wantErr  string // refused; empty when sent
+	}{
+		{"fresh", http.StatusOK, nil, true, false, ""},
+		{"weight under headroom", http.StatusOK, map[string]string{"X-MBX-USED-WEIGHT-1M": "2000"}, false, false, ""},
+		{"weight at headroom", http.StatusOK, map[string]string{"X-MBX-USED-WEIGHT-1M": "2200"}, false, false, "request weight 2200 of 2400 used"},
+		{"orders at headroom", http.StatusOK, map[string]string{"X-MBX-ORDER-COUNT-1M": "1100"}, true, false, "1100 of 1200 orders per 1m0s placed"},
+		{"order counts do not hold queries", http.StatusOK, map[string]string{"X-MBX-ORDER-COUNT-1M": "1100"}, false, false, ""},
+		{"short Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "2"}, false, true, ""},
+		{"429 without Retry-After", http.StatusTooManyRequests, nil, false, false, "backing off after HTTP 429"},
+		{"418 ban", http.StatusTeapot, map[string]string{"Retry-After": "120"}, false, false, "backing off after HTTP 418"},
+	}
+
+	// Counts are valid until their window ends; one ending within
+	// maxRateLimitWait would hold requests instead of refusing them.
+	if left := time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)); left < maxRateLimitWait+time.Second {
+		time.Sleep(left)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(nil)
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for name, value := range tt.headers {
+				resp.Header.Set(name, value)
+			}
+			l.record(key, "fapi", resp)
+
+			wait, err := l.admit(key, "fapi", tt.order)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got wait %v, error %v; want an error containing %q", wait, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (wait > 0) != tt.wantWait || wait > maxRateLimitWait {
+				t.Errorf("got wait %v, want a wait: %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterUsageIsPerAPI(t *testing.T) {
+	l := newRateLimiter(nil)
+	resp := &http.Response{StatusCode: http.StatusTeapot, Header: http.Header{"Retry-After": {"60"}}}
+	l.record("api.binance.com/api", "api", resp)
+
+	if _, err := l.admit("api.binance.com/api", "api", false); err == nil {
+		t.Error("banned API: request admitted")
+	}
+	if _, err := l.admit("fapi.binance.com/fapi", "fapi", false); err != nil {
+		t.Errorf("another API refused after a ban elsewhere: %v", err)
+	}
+}
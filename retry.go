@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Binance error codes that decide whether an order is retried.
+const (
+	errCodeUnknown          = -1000 // unknown error processing the request
+	errCodeDisconnected     = -1001 // internal error, try again
+	errCodeTooManyRequests  = -1003 // request weight exceeded, or IP banned
+	errCodeServerBusy       = -1008 // futures: server overloaded, try again
+	errCodeUnexpectedResp   = -1006 // unexpected response from the message bus
+	errCodeTimeout          = -1007 // backend timed out, send status unknown
+	errCodeTooManyOrders    = -1015 // order rate exceeded
+	errCodeInvalidTimestamp = -1021 // timestamp outside recvWindow
+	errCodeNewOrderRejected = -2010 // spot rejections, including duplicate client IDs
+	errCodeNoSuchOrder      = -2013
+	errCodeDuplicateOrderID = -4116 // futures: client order ID already used
+)
+
+const (
+	maxOrderAttempts  = 4
+	orderRetryBackoff = 500 * time.Millisecond
+)
+
+// errorClass is what an order error says about the order.
+type errorClass int
+
+const (
+	errFatal     errorClass = iota // rejected; sending it again gets the same answer
+	errRetryable                   // not placed; safe to send again
+	errUnknown                     // may or may not have been placed
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errFatal:
+		return "fatal"
+	case errRetryable:
+		return "retryable"
+	case errUnknown:
+		return "unknown"
+	default:
+		return fmt.Sprintf("errorClass(%d)", int(c))
+	}
+}
+
+// classifyError sorts an order error. Rate limits, whether Binance's or our
+// own limiter's, overload and clock skew are retryable. Timeouts, transport
+// errors and server errors without a Binance code leave the order in doubt,
+// as does a duplicate client order ID, which means an earlier attempt got
+// through. Everything else, from filter violations to insufficient balance,
+// is fatal.
+func classifyError(err error) errorClass {
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		return errRetryable
+	}
+	var apiErr *common.APIError
+	if !errors.As(err, &apiErr) {
+		// The request may have reached the exchange before the
+		// connection failed or the response could not be read.
+		return errUnknown
+	}
+	switch apiErr.Code {
+	case errCodeTooManyRequests, errCodeServerBusy, errCodeTooManyOrders, errCodeInvalidTimestamp:
+		return errRetryable
+	case 0, errCodeUnknown, errCodeDisconnected, errCodeUnexpectedResp, errCodeTimeout, errCodeDuplicateOrderID:
+		return errUnknown
+	case errCodeNewOrderRejected:
+		if strings.Contains(apiErr.Message, "Duplicate") {
+			return errUnknown
+		}
+	}
+	return errFatal
+}
+
+// sendWithRetry sends p, retrying errors that leave it unplaced. An order
+// in doubt is looked up by its client order ID and only sent again, under
+// the same ID, once the exchange says it does not have it, so a retry can
+// never place it twice. A timestamp outside recvWindow resyncs the clock
+// before the retry. Dry-run orders are sent once.
+//
+// Callers hold t.mu. It is released during the backoffs, so status, pause
+// and flatten requests are served; see retryWait.
+func (t *Trader) sendWithRetry(send func(string, orderParams) (*orderResult, error), symbol string, p orderParams) (*orderResult, error) {
+	if t.config.DryRun {
+		return send(symbol, p)
+	}
+
+	backoff := orderRetryBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := send(symbol, p)
+		if err == nil {
+			return res, nil
+		}
+
+		class := classifyError(err)
+		if class == errUnknown {
+			res, lookupErr := t.lookupOrder(p, backoff)
+			if lookupErr != nil {
+				return nil, fmt.Errorf("order %s in doubt after %v, and looking it up failed: %v", p.clientOrderID, err, lookupErr)
+			}
+			if res != nil {
+				exchangeLog.Warn("order in doubt was placed", "symbol", symbol, "clientOrderId", p.clientOrderID, "orderId", res.orderID, "status", res.status, "err", err)
+				return res, nil
+			}
+		}
+		if class == errFatal || attempt == maxOrderAttempts {
+			return nil, err
+		}
+		var apiErr *common.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == errCodeInvalidTimestamp {
+			t.resyncClock()
+		}
+
+		orderRetries.WithLabelValues(t.config.Market, class.String()).Inc()
+		exchangeLog.Warn("retrying order", "symbol", symbol, "clientOrderId", p.clientOrderID, "attempt", attempt, "class", class, "backoff", backoff, "err", err)
+		t.retryWait(backoff)
+		backoff *= 2
+	}
+}
+
+// lookupOrder asks the exchange for the order with p's client order ID. It
+// returns nil, nil when the exchange has no such order, and an error when it
+// could not find out. Callers hold t.mu.
+func (t *Trader) lookupOrder(p orderParams, backoff time.Duration) (*orderResult, error) {
+	var err error
+	for attempt := 1; attempt <= maxOrderAttempts; attempt++ {
+		// Give an order that is still being processed time to appear.
+		t.retryWait(backoff)
+		backoff *= 2
+
+		var res *orderResult
+		res, err = t.fetchOrderByClientID(p.clientOrderID, p.side)
+		if err == nil {
+			return res, nil
+		}
+		var apiErr *common.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == errCodeNoSuchOrder {
+			return nil, nil
+		}
+	}
+	return nil, err
+}
+
+// retryWait sleeps for d with t.mu released. The Run loop skips its ticks
+// and Flatten defers to it meanwhile, so nothing else trades until the
+// order being retried has an answer.
+func (t *Trader) retryWait(d time.Duration) {
+	t.retrying++
+	t.mu.Unlock()
+	time.Sleep(d)
+	t.mu.Lock()
+	t.retrying--
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{"our rate limiter", &rateLimitError{api: "api.binance.com/api", until: time.Now(), reason: "request weight 5400 of 6000 used"}, errRetryable},
+		{"request weight", &common.APIError{Code: errCodeTooManyRequests, Message: "Too much request weight used"}, errRetryable},
+		{"server busy", &common.APIError{Code: errCodeServerBusy, Message: "Server is currently overloaded"}, errRetryable},
+		{"order rate", &common.APIError{Code: errCodeTooManyOrders, Message: "Too many new orders"}, errRetryable},
+		{"clock skew", &common.APIError{Code: errCodeInvalidTimestamp, Message: "Timestamp for this request is outside of the recvWindow."}, errRetryable},
+		{"timeout", &common.APIError{Code: errCodeTimeout, Message: "Timeout waiting for response from backend server."}, errUnknown},
+		{"disconnected", &common.APIError{Code: errCodeDisconnected, Message: "Internal error; unable to process your request."}, errUnknown},
+		{"unknown", &common.APIError{Code: errCodeUnknown, Message: "An unknown error occurred while processing the request."}, errUnknown},
+		{"server error without a code", &common.APIError{Message: "<html>502 Bad Gateway</html>"}, errUnknown},
+		{"spot duplicate client ID", &common.APIError{Code: errCodeNewOrderRejected, Message: "Duplicate order sent."}, errUnknown},
+		{"futures duplicate client ID", &common.APIError{Code: errCodeDuplicateOrderID, Message: "ClientOrderId is duplicated."}, errUnknown},
+		{"context deadline", context.DeadlineExceeded, errUnknown},
+		{"wrapped transport error", fmt.Errorf("post order: %w", errors.New("connection reset by peer")), errUnknown},
+		{"insufficient balance", &common.APIError{Code: errCodeNewOrderRejected, Message: "Account has insufficient balance for requested action."}, errFatal},
+		{"would take", &common.APIError{Code: errCodeNewOrderRejected, Message: "Order would immediately match and take."}, errFatal},
+		{"filter failure", &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}, errFatal},
+		{"wrapped API error", fmt.Errorf("placing order: %w", &common.APIError{Code: errCodeTooManyOrders}), errRetryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RiskLimits are the portfolio-level limits enforced by the RiskManager.
+// A zero value disables the corresponding limit.
+type RiskLimits struct {
+	DailyLossLimit       float64 `json:"daily_loss_limit,omitempty"`       // quote currency, realized + unrealized since 00:00 UTC
+	MaxOpenNotional      float64 `json:"max_open_notional,omitempty"`      // across all symbols
+	MaxOrdersPerMinute   int     `json:"max_orders_per_minute,omitempty"`  // all orders count, only those adding exposure are rejected
+	MaxConsecutiveLosses int     `json:"max_consecutive_losses,omitempty"` // closed positions in a row with a loss
+}
+
+// ErrTradingHalted is returned for every order while the kill switch is set.
+var ErrTradingHalted = errors.New("trading halted by kill switch")
+
+// flattener is implemented by Trader so the kill switch can close its
+// position and cancel its orders.
+type flattener interface {
+	Flatten(reason string)
+}
+
+type exposure struct {
+	notional   float64
+	unrealized float64
+}
+
+// riskState is the part of the RiskManager persisted across restarts, so a
+// restart neither clears the kill switch nor resets the day's losses.
+type riskState struct {
+	Killed            bool      `json:"killed"`
+	KillReason        string    `json:"kill_reason,omitempty"`
+	KilledAt          time.Time `json:"killed_at,omitempty"`
+	Day               string    `json:"day"`
+	RealizedToday     float64   `json:"realized_today"`
+	ConsecutiveLosses int       `json:"consecutive_losses"`
+}
+
+// RiskManager sits above every Trader. Each order passes through CheckOrder,
+// and Traders report their exposure and realized PnL to it. When a limit is
+// breached it trips the kill switch: all registered Traders are flattened
+// and trading stays halted until Reset is called.
+type RiskManager struct {
+	mu        sync.Mutex
+	limits    RiskLimits
+	statePath string
+	state     riskState
+	exposures map[string]exposure
+	orders    []time.Time
+	traders   []flattener
+	notifier  *Notifier
+}
+
+func NewRiskManager(limits RiskLimits, stateDir string, notifier *Notifier) (*RiskManager, error) {
+	rm := &RiskManager{
+		limits:    limits,
+		notifier:  notifier,
+		statePath: filepath.Join(stateDir, "risk_state.json"),
+		exposures: make(map[string]exposure),
+	}
+
+	data, err := os.ReadFile(rm.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading risk state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &rm.state); err != nil {
+			return nil, fmt.Errorf("error parsing risk state %s: %v", rm.statePath, err)
+		}
+	}
+	rm.rollDay(time.Now())
+
+	if rm.state.Killed {
+		killSwitch.Set(1)
+		riskLog.Error("kill switch is set", "since", rm.state.KilledAt, "reason", rm.state.KillReason)
+	}
+	return rm, nil
+}
+
+// Register adds a Trader to be flattened when the kill switch trips.
+func (rm *RiskManager) Register(f flattener) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.traders = append(rm.traders, f)
+}
+
+// CheckOrder approves or rejects an order of the given notional. Orders that
+// only reduce a position are always allowed, including while halted, so the
+// kill switch can flatten and stops are never blocked.
+func (rm *RiskManager) CheckOrder(symbol string, notional float64, reducing bool) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-time.Minute)
+	recent := rm.orders[:0]
+	for _, ts := range rm.orders {
+		if ts.After(cutoff) {
+			recent = append(recent, ts)
+		}
+	}
+	rm.orders = recent
+
+	if reducing {
+		rm.orders = append(rm.orders, now)
+		return nil
+	}
+	if rm.state.Killed {
+		return ErrTradingHalted
+	}
+
+	if rm.limits.MaxOrdersPerMinute > 0 && len(rm.orders) >= rm.limits.MaxOrdersPerMinute {
+		return fmt.Errorf("order rate limit reached: %d orders in the last minute", len(rm.orders))
+	}
+
+	if rm.limits.MaxOpenNotional > 0 {
+		var open float64
+		for _, e := range rm.exposures {
+			open += e.notional
+		}
+		if open+notional > rm.limits.MaxOpenNotional {
+			return fmt.Errorf("open notional %.2f + %.2f for %s exceeds max %.2f", open, notional, symbol, rm.limits.MaxOpenNotional)
+		}
+	}
+
+	rm.orders = append(rm.orders, now)
+	return nil
+}
+
+// UpdateExposure records a symbol's open notional and unrealized PnL and
+// checks the daily loss limit against it.
+func (rm *RiskManager) UpdateExposure(symbol string, notional, unrealized float64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.exposures[symbol] = exposure{notional: notional, unrealized: unrealized}
+	rm.checkDailyLoss()
+}
+
+// RecordRealized adds realized PnL from a reduction or close.
+func (rm *RiskManager) RecordRealized(pnl float64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.rollDay(time.Now())
+	rm.state.RealizedToday += pnl
+	rm.save()
+	rm.checkDailyLoss()
+}
+
+// RecordPositionClosed updates the consecutive loss counter with the total
+// PnL of a position that has gone flat.
+func (rm *RiskManager) RecordPositionClosed(pnl float64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if pnl < 0 {
+		rm.state.ConsecutiveLosses++
+	} else {
+		rm.state.ConsecutiveLosses = 0
+	}
+	rm.save()
+
+	if rm.limits.MaxConsecutiveLosses > 0 && rm.state.ConsecutiveLosses >= rm.limits.MaxConsecutiveLosses {
+		rm.trip(fmt.Sprintf("%d consecutive losing positions", rm.state.ConsecutiveLosses))
+	}
+}
+
+// Halted reports whether the kill switch is set.
+func (rm *RiskManager) Halted() bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.state.Killed
+}
+
+// Trip sets the kill switch manually.
+func (rm *RiskManager) Trip(reason string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.trip(reason)
+}
+
+// Reset clears the kill switch and the consecutive loss counter.
+func (rm *RiskManager) Reset() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.state.Killed {
+		riskLog.Info("kill switch reset", "reason", rm.state.KillReason)
+	}
+	rm.state.Killed = false
+	killSwitch.Set(0)
+	rm.state.KillReason = ""
+	rm.state.KilledAt = time.Time{}
+	rm.state.ConsecutiveLosses = 0
+	rm.save()
+}
+
+func (rm *RiskManager) checkDailyLoss() {
+	if rm.limits.DailyLossLimit <= 0 || rm.state.Killed {
+		return
+	}
+
+	rm.rollDay(time.Now())
+	pnl := rm.state.RealizedToday
+	for _, e := range rm.exposures {
+		pnl += e.unrealized
+	}
+	if pnl <= -rm.limits.DailyLossLimit {
+		rm.trip(fmt.Sprintf("daily loss %.2f reached limit %.2f", -pnl, rm.limits.DailyLossLimit))
+	}
+}
+
+// trip sets the kill switch and flattens every Trader. Traders call into the
+// RiskManager while holding their own lock, so flattening runs on its own
+// goroutine to keep the lock order one-way.
+func (rm *RiskManager) trip(reason string) {
+	if rm.state.Killed {
+		return
+	}
+
+	rm.state.Killed = true
+	killSwitch.Set(1)
+	rm.state.KillReason = reason
+	rm.state.KilledAt = time.Now()
+	rm.save()
+	riskLog.Error("KILL SWITCH: flattening all positions and halting trading", "reason", reason)
+	rm.notifier.Notify(Notification{
+		Event: eventKillSwitch,
+		Text:  fmt.Sprintf("KILL SWITCH: %s. Flattening all positions and halting trading.", reason),
+	})
+
+	traders := append([]flattener(nil), rm.traders...)
+	go func() {
+		for _, f := range traders {
+			f.Flatten(reason)
+		}
+	}()
+}
+
+// rollDay resets the realized PnL at the UTC day boundary.
+func (rm *RiskManager) rollDay(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if rm.state.Day != day {
+		rm.state.Day = day
+		rm.state.RealizedToday = 0
+	}
+}
+
+func (rm *RiskManager) save() {
+	data, err := json.MarshalIndent(rm.state, "", "    ")
+	if err != nil {
+		riskLog.Error("error encoding risk state", "err", err)
+		return
+	}
+	if err := writeFileAtomic(rm.statePath, data); err != nil {
+		riskLog.Error("error writing risk state", "err", err)
+	}
+}
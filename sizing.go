@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Position sizing models, selected with Config.SizingModel.
+const (
+	sizingNotional   = "notional"   // MaxPosition in quote currency
+	sizingQuantity   = "quantity"   // OrderQuantity in base asset (contracts on coinm)
+	sizingEquityPct  = "equity_pct" // EquityPct percent of account equity
+	sizingRisk       = "risk"       // lose RiskPct percent of equity if the stop is hit
+	sizingVolatility = "volatility" // target VolTargetPct daily volatility of equity
+)
+
+// positionNotional returns the notional (quote currency) to enter with at
+// the given price. Whatever the model, the result is capped at MaxPosition.
+func (t *Trader) positionNotional(price float64) (float64, error) {
+	var notional float64
+
+	switch t.config.SizingModel {
+	case sizingNotional:
+		notional = t.config.MaxPosition
+	case sizingQuantity:
+		if t.config.Market == "coinm" {
+			notional = t.config.OrderQuantity * t.symbol.contractSize
+		} else {
+			notional = t.config.OrderQuantity * price
+		}
+	case sizingEquityPct:
+		equity, err := t.accountEquity(price)
+		if err != nil {
+			return 0, err
+		}
+		notional = equity * t.config.EquityPct / 100
+	case sizingRisk:
+		equity, err := t.accountEquity(price)
+		if err != nil {
+			return 0, err
+		}
+		// The handlers stop out at stopLossPct against the entry, so a
+		// position of notional N loses N*stopLossPct at the stop.
+		notional = equity * t.config.RiskPct / 100 / stopLossPct
+	case sizingVolatility:
+		equity, err := t.accountEquity(price)
+		if err != nil {
+			return 0, err
+		}
+		vol, err := t.dailyVolatility()
+		if err != nil {
+			return 0, err
+		}
+		notional = equity * t.config.VolTargetPct / 100 / vol
+	default:
+		return 0, fmt.Errorf("unknown sizing model: %s", t.config.SizingModel)
+	}
+
+	if notional > t.config.MaxPosition {
+		notional = t.config.MaxPosition
+	}
+	if notional <= 0 {
+		return 0, fmt.Errorf("%s sizing produced a non-positive notional: %f", t.config.SizingModel, notional)
+	}
+	return notional, nil
+}
+
+// orderQuantity converts a notional to an order quantity at price: base
+// asset on spot and usdm, whole contracts on coinm, where a contract is
+// worth a fixed contractSize in quote whatever the price. The quantity is
+// rounded down to the lot step (whole contracts on coinm); the notional it
+// amounts to is returned with it.
+func (t *Trader) orderQuantity(notional, price float64) (string, float64) {
+	if t.config.Market == "coinm" {
+		contracts := t.roundLot(notional / t.symbol.contractSize)
+		return formatQuantity(contracts), contracts * t.symbol.contractSize
+	}
+	quantity := t.roundLot(notional / price)
+	return formatQuantity(quantity), quantity * price
+}
+
+// roundLot rounds a quantity down to the symbol's lot step, whole contracts
+// on coinm. The epsilon keeps an exact multiple, such as a full close, from
+// losing a step to float error.
+func (t *Trader) roundLot(quantity float64) float64 {
+	step := t.symbol.filters.stepSize
+	if step <= 0 && t.config.Market == "coinm" {
+		step = 1
+	}
+	if step <= 0 {
+		return quantity
+	}
+	return math.Floor(quantity/step+1e-9) * step
+}
+
+// dailyVolatility returns the standard deviation of 1m log returns over the
+// configured lookback, scaled to one day.
+func (t *Trader) dailyVolatility() (float64, error) {
+	closes := t.ds.KlineCloses(t.config.Pair)
+	if len(closes) < t.config.VolLookback+1 {
+		return 0, fmt.Errorf("need %d closed klines for volatility sizing, have %d", t.config.VolLookback+1, len(closes))
+	}
+	closes = closes[len(closes)-t.config.VolLookback-1:]
+
+	returns := make([]float64, len(closes)-1)
+	var mean float64
+	for i := 1; i < len(closes); i++ {
+		returns[i-1] = math.Log(closes[i] / closes[i-1])
+		mean += returns[i-1]
+	}
+	mean /= float64(len(returns))
+
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(returns) - 1)
+
+	vol := math.Sqrt(variance) * math.Sqrt(24*60)
+	if vol <= 0 {
+		return 0, fmt.Errorf("realized volatility is zero")
+	}
+	return vol, nil
+}
+
+// seedKlineHistory backfills closed 1m klines from REST so volatility sizing
+// can be used as soon as the bot starts.
+func (t *Trader) seedKlineHistory() error {
+	symbol := strings.ToUpper(t.config.Pair)
+	limit := t.config.VolLookback + 2
+	ctx := context.Background()
+
+	var closes []string
+	var closeTimes []int64
+	switch t.config.Market {
+	case "spot":
+		klines, err := t.spotClient.NewKlinesService().Symbol(symbol).Interval("1m").Limit(limit).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range klines {
+			closes, closeTimes = append(closes, k.Close), append(closeTimes, k.CloseTime)
+		}
+	case "usdm":
+		klines, err := t.usdmClient.NewKlinesService().Symbol(symbol).Interval("1m").Limit(limit).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range klines {
+			closes, closeTimes = append(closes, k.Close), append(closeTimes, k.CloseTime)
+		}
+	case "coinm":
+		klines, err := t.coinmClient.NewKlinesService().Symbol(symbol).Interval("1m").Limit(limit).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range klines {
+			closes, closeTimes = append(closes, k.Close), append(closeTimes, k.CloseTime)
+		}
+	default:
+		return fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+
+	now := time.Now().UnixMilli()
+	var values []float64
+	for i, c := range closes {
+		// The last kline is usually still open; the stream adds it on close.
+		if closeTimes[i] > now {
+			continue
+		}
+		v, err := strconv.ParseFloat(c, 64)
+		if err != nil {
+			return fmt.Errorf("invalid kline close %q: %v", c, err)
+		}
+		values = append(values, v)
+	}
+
+	t.ds.SeedKlineCloses(t.config.Pair, values)
+	return nil
+}
+
+// accountEquity returns the account's equity in quote currency, fetched live
+// from the exchange. On spot it is the quote balance plus the base balance
+// valued at price; on futures it is the margin balance.
+func (t *Trader) accountEquity(price float64) (float64, error) {
+	ctx := context.Background()
+
+	switch t.config.Market {
+	case "spot":
+		account, err := t.spotClient.NewGetAccountService().Do(ctx, t.spotRecvWindow())
+		if err != nil {
+			return 0, fmt.Errorf("error fetching spot balances: %v", err)
+		}
+		var equity float64
+		for _, b := range account.Balances {
+			if b.Asset != t.symbol.baseAsset && b.Asset != t.symbol.quoteAsset {
+				continue
+			}
+			free, err := strconv.ParseFloat(b.Free, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid free %s balance %q: %v", b.Asset, b.Free, err)
+			}
+			locked, err := strconv.ParseFloat(b.Locked, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid locked %s balance %q: %v", b.Asset, b.Locked, err)
+			}
+			if b.Asset == t.symbol.baseAsset {
+				equity += (free + locked) * price
+			} else {
+				equity += free + locked
+			}
+		}
+		return equity, nil
+	case "usdm":
+		account, err := t.usdmClient.NewGetAccountService().Do(ctx, t.usdmRecvWindow())
+		if err != nil {
+			return 0, fmt.Errorf("error fetching futures account: %v", err)
+		}
+		equity, err := strconv.ParseFloat(account.TotalMarginBalance, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid margin balance %q: %v", account.TotalMarginBalance, err)
+		}
+		return equity, nil
+	case "coinm":
+		account, err := t.coinmClient.NewGetAccountService().Do(ctx, t.coinmRecvWindow())
+		if err != nil {
+			return 0, fmt.Errorf("error fetching delivery account: %v", err)
+		}
+		for _, a := range account.Assets {
+			if a.Asset == t.symbol.marginAsset {
+				balance, err := strconv.ParseFloat(a.MarginBalance, 64)
+				if err != nil {
+					return 0, fmt.Errorf("invalid margin balance %q: %v", a.MarginBalance, err)
+				}
+				// Margin is held in the base coin; value it in quote.
+				return balance * price, nil
+			}
+		}
+		return 0, fmt.Errorf("no %s balance in delivery account", t.symbol.marginAsset)
+	default:
+		return 0, fmt.Errorf("unsupported market type: %s", t.config.Market)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withSpotAccount points the test Trader's spot client at a server that
+// reports account, a /api/v3/account response.
+func withSpotAccount(t *testing.T, tr *Trader, account string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/account" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(account))
+	}))
+	t.Cleanup(server.Close)
+	tr.spotClient = Environment{RestURL: server.URL}.SpotClient("key", "secret")
+}
+
+func TestPositionNotional(t *testing.T) {
+	// 900 USDT free, 100 locked and 0.02 BTC: 2000 USDT of equity at 50000.
+	const account = `{"balances":[{"asset":"USDT","free":"900","locked":"100"},{"asset":"BTC","free":"0.015","locked":"0.005"},{"asset":"ETH","free":"3","locked":"0"}]}`
+	const price = 50000
+	// 1m closes of 100, 110, 100: log returns of +-ln(1.1) around a zero mean.
+	vol := math.Log(1.1) * math.Sqrt(2) * math.Sqrt(24*60)
+
+	tests := []struct {
+		name    string
+		config  Config
+		market  string
+		klines  []float64
+		want    float64
+		wantErr string
+	}{
+		{name: "notional", config: Config{SizingModel: sizingNotional, MaxPosition: 500}, want: 500},
+		{name: "quantity", config: Config{SizingModel: sizingQuantity, OrderQuantity: 0.004, MaxPosition: 1000}, want: 200},
+		{name: "quantity capped", config: Config{SizingModel: sizingQuantity, OrderQuantity: 0.1, MaxPosition: 1000}, want: 1000},
+		{name: "coinm quantity is contracts", config: Config{SizingModel: sizingQuantity, OrderQuantity: 3, MaxPosition: 1000}, market: "coinm", want: 300},
+		{name: "equity pct", config: Config{SizingModel: sizingEquityPct, EquityPct: 10, MaxPosition: 1000}, want: 200},
+		{name: "risk", config: Config{SizingModel: sizingRisk, RiskPct: 0.5, MaxPosition: 5000}, want: 2000 * 0.005 / stopLossPct},
+		{name: "risk capped", config: Config{SizingModel: sizingRisk, RiskPct: 1, MaxPosition: 1000}, want: 1000},
+		{name: "volatility", config: Config{SizingModel: sizingVolatility, VolTargetPct: 2, VolLookback: 2, MaxPosition: 1000}, klines: []float64{120, 100, 110, 100}, want: 40 / vol},
+		{name: "volatility without history", config: Config{SizingModel: sizingVolatility, VolTargetPct: 2, VolLookback: 2, MaxPosition: 1000}, klines: []float64{100, 110}, wantErr: "need 3 closed klines"},
+		{name: "volatility of a flat market", config: Config{SizingModel: sizingVolatility, VolTargetPct: 2, VolLookback: 2, MaxPosition: 1000}, klines: []float64{100, 100, 100}, wantErr: "volatility is zero"},
+		{name: "nothing to size", config: Config{SizingModel: sizingQuantity, MaxPosition: 1000}, wantErr: "non-positive notional"},
+		{name: "unknown model", config: Config{SizingModel: "kelly", MaxPosition: 1000}, wantErr: "unknown sizing model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			tr := newTestTrader(t, &config)
+			withSpotAccount(t, tr, account)
+			if tt.market != "" {
+				tr.config.Market = tt.market
+				tr.symbol.contractSize = 100
+			}
+			if tt.klines != nil {
+				tr.ds.SeedKlineCloses(tr.config.Pair, tt.klines)
+			}
+
+			got, err := tr.positionNotional(price)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v; want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundLot(t *testing.T) {
+	tests := []struct {
+		name     string
+		market   string
+		step     float64
+		quantity float64
+		want     float64
+	}{
+		{"down to the step", "spot", 0.001, 0.0123456, 0.012},
+		{"exact multiple kept despite float error", "spot", 0.001, 0.1 + 0.2, 0.3},
+		{"below one step", "usdm", 0.001, 0.0009, 0},
+		{"no step", "spot", 0, 0.0123456, 0.0123456},
+		{"coinm whole contracts", "coinm", 0, 7.9, 7},
+		{"coinm contract step", "coinm", 1, 12.999, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTrader(t, nil)
+			tr.config.Market = tt.market
+			tr.symbol.filters.stepSize = tt.step
+			if got := tr.roundLot(tt.quantity); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("roundLot(%v) = %v, want %v", tt.quantity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderQuantity(t *testing.T) {
+	tr := newTestTrader(t, nil)
+	tr.symbol.filters.stepSize = 0.001
+	if qty, notional := tr.orderQuantity(1000, 30000); qty != "0.033" || math.Abs(notional-990) > 1e-9 {
+		t.Errorf("spot: got %s for %v, want 0.033 for 990", qty, notional)
+	}
+
+	tr.config.Market, tr.symbol.contractSize, tr.symbol.filters.stepSize = "coinm", 100, 0
+	if qty, notional := tr.orderQuantity(950, 30000); qty != "9" || notional != 900 {
+		t.Errorf("coinm: got %s for %v, want 9 contracts for 900", qty, notional)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const (
+	// clockSyncInterval is how often the offset to the exchange's clock is
+	// measured again.
+	clockSyncInterval = time.Minute
+	// clockDriftWarn is the offset above which the local clock is worth
+	// fixing, even though signed requests are corrected for it.
+	clockDriftWarn = time.Second
+	// maxRecvWindowMs is the largest recvWindow Binance accepts.
+	maxRecvWindowMs = 60000
+)
+
+// serverClock is the offset between the local clock and the exchange's,
+// shared by every Binance client: signed requests carry a timestamp that
+// must fall within recvWindow of the server's time, or they fail with
+// -1021. Clients are built with the current offset and traders apply new
+// ones as they are measured.
+var serverClock = &clockOffset{}
+
+type clockOffset struct {
+	mu     sync.Mutex
+	offset int64 // local minus server time, ms, as in the clients' TimeOffset
+	rtt    time.Duration
+	synced time.Time
+}
+
+// ClockStatus is the last clock sync, for the control API.
+type ClockStatus struct {
+	OffsetMs int64     `json:"offset_ms"` // positive when the local clock is ahead
+	RTTMs    int64     `json:"rtt_ms"`
+	Synced   time.Time `json:"synced"`
+}
+
+// Offset returns the last measured offset in ms, or 0 before the first
+// sync.
+func (c *clockOffset) Offset() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+func (c *clockOffset) Status() ClockStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClockStatus{OffsetMs: c.offset, RTTMs: c.rtt.Milliseconds(), Synced: c.synced}
+}
+
+// Sync measures the offset to env's server time. The server is taken to
+// have read its clock halfway through the round trip.
+func (c *clockOffset) Sync(env Environment) error {
+	ctx := context.Background()
+	sent := time.Now()
+	var serverTime int64
+	var err error
+	switch env.Market {
+	case "spot":
+		serverTime, err = env.SpotClient("", "").NewServerTimeService().Do(ctx)
+	case "usdm":
+		serverTime, err = env.USDMClient("", "").NewServerTimeService().Do(ctx)
+	case "coinm":
+		serverTime, err = env.CoinMClient("", "").NewServerTimeService().Do(ctx)
+	default:
+		return fmt.Errorf("unsupported market type: %s", env.Market)
+	}
+	if err != nil {
+		clockSyncErrors.Inc()
+		return fmt.Errorf("error fetching server time: %v", err)
+	}
+	rtt := time.Since(sent)
+	offset := sent.Add(rtt/2).UnixMilli() - serverTime
+
+	c.mu.Lock()
+	previous, first := c.offset, c.synced.IsZero()
+	c.offset, c.rtt, c.synced = offset, rtt, time.Now()
+	c.mu.Unlock()
+
+	clockOffsetSeconds.Set(float64(offset) / 1000)
+	attrs := []any{"offsetMs", offset, "rtt", rtt.Round(time.Millisecond)}
+	if !first {
+		attrs = append(attrs, "driftMs", offset-previous)
+	}
+	if time.Duration(abs(offset))*time.Millisecond >= clockDriftWarn {
+		exchangeLog.Warn("local clock is off the exchange's, correcting signed requests", attrs...)
+	} else {
+		exchangeLog.Debug("synced server time", attrs...)
+	}
+	return nil
+}
+
+// Run syncs every clockSyncInterval. It never returns.
+func (c *clockOffset) Run(env Environment) {
+	for {
+		time.Sleep(clockSyncInterval)
+		if err := c.Sync(env); err != nil {
+			exchangeLog.Warn("server time sync failed, keeping the last offset", "offsetMs", c.Offset(), "err", err)
+		}
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// applyClockOffset sets the shared offset on the trader's client. Requests
+// read it while they are built, so callers hold t.mu.
+func (t *Trader) applyClockOffset() {
+	offset := serverClock.Offset()
+	switch t.config.Market {
+	case "spot":
+		t.spotClient.TimeOffset = offset
+	case "usdm":
+		t.usdmClient.TimeOffset = offset
+	case "coinm":
+		t.coinmClient.TimeOffset = offset
+	}
+}
+
+// resyncClock measures the offset at once, after the exchange rejected a
+// timestamp. Callers hold t.mu.
+func (t *Trader) resyncClock() {
+	if err := serverClock.Sync(t.env); err != nil {
+		exchangeLog.Warn("server time sync failed", "err", err)
+		return
+	}
+	t.applyClockOffset()
+}
+
+// The request options below set the configured recvWindow on signed
+// requests; with none configured Binance's default of 5000ms applies.
+
+func (t *Trader) spotRecvWindow() binance.RequestOption {
+	return binance.WithRecvWindow(int64(t.config.RecvWindowMs))
+}
+
+func (t *Trader) usdmRecvWindow() futures.RequestOption {
+	return futures.WithRecvWindow(int64(t.config.RecvWindowMs))
+}
+
+func (t *Trader) coinmRecvWindow() delivery.RequestOption {
+	return delivery.WithRecvWindow(int64(t.config.RecvWindowMs))
+}
@@ -2,12 +2,12 @@
 package main
 
 import (
-    "context"
     "crypto/hmac"
     "crypto/sha256"
     "encoding/hex"
     "fmt"
-    "log"
+    "log/slog"
+    "math"
     "os"
     "sort"
     "strconv"
@@ -18,7 +18,6 @@ import (
     "github.com/adshao/go-binance/v2"
     "github.com/adshao/go-binance/v2/futures"
     "github.com/adshao/go-binance/v2/delivery"
-    "github.com/fatih/color"
 )
 
 
@@ -30,24 +29,66 @@ const (
 	SecondaryEntry
 )
 
+func (s TraderState) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case InitialEntry:
+		return "InitialEntry"
+	case SecondaryEntry:
+		return "SecondaryEntry"
+	default:
+		return fmt.Sprintf("TraderState(%d)", int(s))
+	}
+}
+
+// stopLossPct is the adverse move from entry at which the handlers close
+// (and, from InitialEntry, reverse) the position.
+const stopLossPct = 0.01
+
+const reasonStopLoss = "stop loss"
+
 type Trader struct {
     config      *Config
     ds          *DataStore
     ws          *WebSocket
+    risk        *RiskManager
+    ledger      *Ledger
+    journal     *Journal
+    notifier    *Notifier
+    env         Environment
     apiKey      string
     secretKey   string
     mu          sync.Mutex
     state       TraderState
+    paused      bool
+    firedTiers  map[float64]bool // take-profit tiers hit since the last entry, by gain
     entryPrice  float64
     entrySize   float64
     currentSize float64
     isLong      bool
+    leverage    float64
+    hedgeMode   bool
+    symbol      symbolInfo
+    assetPrices map[string]cachedPrice
+    fundingFrom time.Time
+    lastStatus  time.Time
     spotClient  *binance.Client
     usdmClient  *futures.Client
     coinmClient *delivery.Client
+
+    dryRunOrders int64                         // order IDs handed out to simulated fills with -dry-run
+    dryRunBook   map[int64]*dryRunRestingOrder // dry-run limit orders not yet filled or cancelled
+
+    orders        *OrderManager
+    parent        *parentOrder // the order being worked by an execution algorithm, if any
+    parentSeq     int64
+    flattening    bool   // closes go at market while set
+    flattenReason string // a flatten waiting for the parent order to stop
+    retrying      int    // orders waiting to be retried with t.mu released
 }
 
-func NewTrader(config *Config, ds *DataStore, ws *WebSocket, isBuy bool) (*Trader, error) {
+func NewTrader(config *Config, ds *DataStore, ws *WebSocket, risk *RiskManager, ledger *Ledger, journal *Journal, notifier *Notifier, isBuy bool) (*Trader, error) {
     apiKey := os.Getenv("API_KEY")
     secretKey := os.Getenv("SECRET_KEY")
 
@@ -56,26 +97,56 @@ func NewTrader(config *Config, ds *DataStore, ws *WebSocket, isBuy bool) (*Trade
     }
 
     t := &Trader{
-        config:    config,
-        ds:        ds,
-        ws:        ws,
-        apiKey:    apiKey,
-        secretKey: secretKey,
-        state:     Idle,
-        isLong:    isBuy,
+        config:      config,
+        ds:          ds,
+        ws:          ws,
+        risk:        risk,
+        ledger:      ledger,
+        journal:     journal,
+        notifier:    notifier,
+        apiKey:      apiKey,
+        secretKey:   secretKey,
+        state:       Idle,
+        firedTiers:  make(map[float64]bool),
+        isLong:      isBuy,
+        leverage:    1,
+        fundingFrom: time.Now(),
+        orders:      NewOrderManager(config, ledger),
     }
 
+    t.env = resolveEnvironment(config)
     switch config.Market {
     case "spot":
-        t.spotClient = binance.NewClient(apiKey, secretKey)
+        t.spotClient = t.env.SpotClient(apiKey, secretKey)
     case "usdm":
-        t.usdmClient = futures.NewClient(apiKey, secretKey)
+        t.usdmClient = t.env.USDMClient(apiKey, secretKey)
     case "coinm":
-        t.coinmClient = delivery.NewClient(apiKey, secretKey)
+        t.coinmClient = t.env.CoinMClient(apiKey, secretKey)
     default:
         return nil, fmt.Errorf("unsupported market type: %s", config.Market)
     }
 
+    if err := t.loadSymbolInfo(); err != nil {
+        return nil, fmt.Errorf("error loading symbol info: %v", err)
+    }
+
+    if config.Market != "spot" {
+        if config.DryRun {
+            // A dry run leaves the account untouched and assumes the
+            // configured settings.
+            t.leverage = float64(config.Leverage)
+            t.hedgeMode = config.PositionMode == "hedge"
+        } else if err := t.configureFutures(); err != nil {
+            return nil, err
+        }
+    }
+
+    if config.SizingModel == sizingVolatility {
+        if err := t.seedKlineHistory(); err != nil {
+            traderLog.Warn("could not backfill klines, volatility sizing waits for the stream", "err", err)
+        }
+    }
+
     return t, nil
 }
 
@@ -102,154 +173,8 @@ func (t *Trader) signRequest(params map[string]string) string {
 }
 
 
-func (t *Trader) PlaceMarketOrder(side binance.SideType, quantity string) error {
-    symbol := t.config.Pair
-
-    switch t.config.Market {
-    case "spot":
-        _, err := t.spotClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(side).
-            Type(binance.OrderTypeMarket).
-            Quantity(quantity).
-            Do(context.Background())
-        return err
-    case "usdm":
-        _, err := t.usdmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(futures.SideType(side)).
-            Type(futures.OrderTypeMarket).
-            Quantity(quantity).
-            Do(context.Background())
-        return err
-    case "coinm":
-        _, err := t.coinmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(delivery.SideType(side)).
-            Type(delivery.OrderTypeMarket).
-            Quantity(quantity).
-            Do(context.Background())
-        return err
-    default:
-        return fmt.Errorf("unsupported market type: %s", t.config.Market)
-    }
-}
-
-
-func (t *Trader) PlaceLimitOrder(side binance.SideType, quantity string, price string) error {
-    symbol := t.config.Pair
-
-    switch t.config.Market {
-    case "spot":
-        _, err := t.spotClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(side).
-            Type(binance.OrderTypeLimit).
-            TimeInForce(binance.TimeInForceTypeGTC).
-            Quantity(quantity).
-            Price(price).
-            Do(context.Background())
-        return err
-    case "usdm":
-        _, err := t.usdmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(futures.SideType(side)).
-            Type(futures.OrderTypeLimit).
-            TimeInForce(futures.TimeInForceTypeGTC).
-            Quantity(quantity).
-            Price(price).
-            Do(context.Background())
-        return err
-    case "coinm":
-        _, err := t.coinmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(delivery.SideType(side)).
-            Type(delivery.OrderTypeLimit).
-            TimeInForce(delivery.TimeInForceTypeGTC).
-            Quantity(quantity).
-            Price(price).
-            Do(context.Background())
-        return err
-    default:
-        return fmt.Errorf("unsupported market type: %s", t.config.Market)
-    }
-}
-
-
-func (t *Trader) PlaceStopLossOrder(side binance.SideType, quantity string, stopPrice string) error {
-	symbol := t.config.Pair
-
-	switch t.config.Market {
-	case "spot":
-		_, err := t.spotClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(side).
-			Type(binance.OrderTypeStopLoss).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	case "usdm":
-		_, err := t.usdmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(futures.SideType(side)).
-			Type(futures.OrderTypeStop).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	case "coinm":
-		_, err := t.coinmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(delivery.SideType(side)).
-			Type(delivery.OrderTypeStop).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	default:
-		return fmt.Errorf("unsupported market type: %s", t.config.Market)
-	}
-}
-
-func (t *Trader) PlaceTakeProfitOrder(side binance.SideType, quantity string, stopPrice string) error {
-	symbol := t.config.Pair
-
-	switch t.config.Market {
-	case "spot":
-		_, err := t.spotClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(side).
-			Type(binance.OrderTypeTakeProfit).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	case "usdm":
-		_, err := t.usdmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(futures.SideType(side)).
-			Type(futures.OrderTypeTakeProfit).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	case "coinm":
-		_, err := t.coinmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(delivery.SideType(side)).
-			Type(delivery.OrderTypeTakeProfit).
-			Quantity(quantity).
-			StopPrice(stopPrice).
-			Do(context.Background())
-		return err
-	default:
-		return fmt.Errorf("unsupported market type: %s", t.config.Market)
-	}
-}
-
 func (t *Trader) Run() {
-	log.Println(color.GreenString("Trader started"))
+	traderLog.Info("trader started", "symbol", t.config.Pair, "market", t.config.Market, "long", t.isLong)
 
 	for {
 		marketData := t.ds.GetMarketData(t.config.Pair)
@@ -259,14 +184,45 @@ func (t *Trader) Run() {
 		}
 
 		t.mu.Lock()
-		currentPrice := marketData.Price
-		switch t.state {
-		case Idle:
-			t.handleIdleState(currentPrice)
-		case InitialEntry:
-			t.handleInitialEntryState(currentPrice)
-		case SecondaryEntry:
-			t.handleSecondaryEntryState(currentPrice)
+		if t.retrying > 0 {
+			// An order is between attempts; act once it has an answer.
+			t.mu.Unlock()
+			time.Sleep(time.Second)
+			continue
+		}
+		t.applyClockOffset()
+		currentPrice := t.triggerPrice(marketData)
+		if currentPrice <= 0 {
+			t.mu.Unlock()
+			time.Sleep(time.Second)
+			continue
+		}
+		if !t.paused && !t.risk.Halted() {
+			switch t.state {
+			case Idle:
+				t.handleIdleState(currentPrice)
+			case InitialEntry:
+				t.handleInitialEntryState(currentPrice)
+			case SecondaryEntry:
+				t.handleSecondaryEntryState(currentPrice)
+			}
+		}
+		if t.flattenReason != "" {
+			reason := t.flattenReason
+			t.flattenReason = ""
+			t.flatten(reason)
+		}
+		t.risk.UpdateExposure(t.config.Pair, t.currentSize, t.unrealizedPnL(currentPrice))
+		t.updateMetrics(currentPrice)
+		if t.config.Market == "usdm" && !t.config.DryRun && time.Since(t.fundingFrom) >= fundingPollInterval {
+			t.syncFunding()
+		}
+		if !t.config.DryRun && time.Since(t.orders.lastReconcile) >= orderReconcileInterval {
+			t.reconcileOrders()
+		}
+		if time.Since(t.lastStatus) >= statusInterval {
+			t.logStatus(marketData)
+			t.lastStatus = time.Now()
 		}
 		t.mu.Unlock()
 
@@ -274,20 +230,102 @@ func (t *Trader) Run() {
 	}
 }
 
+const statusInterval = 10 * time.Second
+
+// logStatus prints the position summary, including mark price, funding and
+// the estimated liquidation price on futures markets. Callers hold t.mu.
+func (t *Trader) logStatus(md *MarketData) {
+	pnl := t.ledger.Summary(md.LastPrice())
+	pnlAttrs := slog.Group("pnl",
+		"unrealized", pnl.UnrealizedPnL,
+		"sessionRealized", pnl.Session.RealizedPnL,
+		"sessionFees", pnl.Session.Fees,
+		"sessionFunding", pnl.Session.Funding,
+		"sessionNet", pnl.Session.Net(),
+		"lifetimeNet", pnl.Lifetime.Net())
+
+	if t.state == Idle {
+		traderLog.Info("status", "symbol", t.config.Pair, "state", t.state, "last", md.LastPrice(), pnlAttrs)
+		return
+	}
+
+	direction := "short"
+	if t.isLong {
+		direction = "long"
+	}
+	args := []interface{}{"symbol", t.config.Pair, "direction", direction, "state", t.state,
+		"entry", t.entryPrice, "size", t.currentSize, "last", md.LastPrice()}
+
+	if t.config.Market != "spot" {
+		markPrice, _, fundingRate, nextFunding := md.MarkPriceInfo()
+		liqPrice := estimateLiquidationPrice(t.entryPrice, t.leverage, t.config.MaintenanceMarginRate, t.isLong)
+		if t.config.Market == "coinm" {
+			liqPrice = estimateInverseLiquidationPrice(t.entryPrice, t.leverage, t.config.MaintenanceMarginRate, t.isLong)
+		}
+		args = append(args, "mark", markPrice, "fundingPct", fundingRate*100, "nextFunding", nextFunding,
+			"estLiq", liqPrice, "leverage", t.leverage)
+	}
+
+	traderLog.Info("status", append(args, pnlAttrs)...)
+}
+
+// triggerPrice returns the price the state handlers evaluate against. With
+// "bid_ask" it is the price we could actually trade at: the ask for long
+// entries and short exits, the bid for short entries and long exits.
+func (t *Trader) triggerPrice(md *MarketData) float64 {
+	switch t.config.PriceSource {
+	case "bid_ask":
+		bid, ask := md.BestBidAsk()
+		selling := t.isLong == (t.state != Idle)
+		if selling {
+			return bid
+		}
+		return ask
+	case "mid":
+		bid, ask := md.BestBidAsk()
+		if bid <= 0 || ask <= 0 {
+			return 0
+		}
+		return (bid + ask) / 2
+	case "mark":
+		markPrice, _, _, _ := md.MarkPriceInfo()
+		return markPrice
+	default:
+		return md.LastPrice()
+	}
+}
+
+// checkSpread blocks market orders while the spread is wider than
+// MaxSpreadBps, or while there is no book to measure it against.
+func (t *Trader) checkSpread() error {
+	if t.config.MaxSpreadBps <= 0 {
+		return nil
+	}
+
+	marketData := t.ds.GetMarketData(t.config.Pair)
+	if marketData == nil {
+		return fmt.Errorf("no market data for %s", t.config.Pair)
+	}
+
+	spread := marketData.SpreadBps()
+	if spread < 0 {
+		return fmt.Errorf("no order book data to check spread")
+	}
+	if spread > t.config.MaxSpreadBps {
+		return fmt.Errorf("spread %.2f bps exceeds max %.2f bps", spread, t.config.MaxSpreadBps)
+	}
+	return nil
+}
+
 func formatQuantity(quantity float64) string {
-	log.Printf("Debug: Entering formatQuantity function with quantity: %f", quantity)
-	
 	// Format quantity with 8 decimal places
 	quantityStr := strconv.FormatFloat(quantity, 'f', 8, 64)
-	log.Printf("Debug: Formatted quantity with 8 decimal places: %s", quantityStr)
 	
 	// Remove trailing zeros
 	quantityStr = strings.TrimRight(quantityStr, "0")
-	log.Printf("Debug: Quantity after removing trailing zeros: %s", quantityStr)
 	
 	// Remove trailing decimal point if it's the last character
 	quantityStr = strings.TrimRight(quantityStr, ".")
-	log.Printf("Debug: Final formatted quantity: %s", quantityStr)
 	
 	return quantityStr
 }
@@ -296,21 +334,21 @@ func formatQuantity(quantity float64) string {
 func (t *Trader) handleIdleState(currentPrice float64) {
 	if t.config.EntrySignal == "market" {
 		if t.isLong {
-			t.enterLongPosition(currentPrice)
+			t.enterLongPosition(currentPrice, "market entry")
 		} else {
-			t.enterShortPosition(currentPrice)
+			t.enterShortPosition(currentPrice, "market entry")
 		}
 	} else {
 		entryPrice, err := strconv.ParseFloat(t.config.EntrySignal, 64)
 		if err != nil {
-			log.Printf(color.RedString("Error parsing entry price: %v", err))
+			traderLog.Error("error parsing entry price", "entrySignal", t.config.EntrySignal, "err", err)
 			return
 		}
 
 		if t.isLong && currentPrice <= entryPrice {
-			t.enterLongPosition(currentPrice)
+			t.enterLongPosition(currentPrice, fmt.Sprintf("price at or below entry signal %s", t.config.EntrySignal))
 		} else if !t.isLong && currentPrice >= entryPrice {
-			t.enterShortPosition(currentPrice)
+			t.enterShortPosition(currentPrice, fmt.Sprintf("price at or above entry signal %s", t.config.EntrySignal))
 		}
 	}
 }
@@ -331,249 +369,575 @@ func (t *Trader) handleSecondaryEntryState(currentPrice float64) {
 	}
 }
 
-func (t *Trader) enterLongPosition(currentPrice float64) {
+func (t *Trader) enterLongPosition(currentPrice float64, reason string) {
     symbol := strings.ToUpper(t.config.Pair)
 
-    log.Printf("Debug: Entering enterLongPosition function")
-    log.Printf("Debug: Symbol: %s", symbol)
-    log.Printf("Debug: Current Price: %f", currentPrice)
-    log.Printf("Debug: Max Position: %f", t.config.MaxPosition)
-
     if symbol == "" {
-        log.Printf(color.RedString("Error: Symbol is empty"))
+        traderLog.Error("symbol is empty")
         return
     }
 
     // Check if the current price is valid (not zero)
     if currentPrice <= 0 {
-        log.Printf(color.YellowString("Warning: Current price is zero or negative. Waiting for valid price data."))
+        traderLog.Warn("current price is zero or negative, waiting for valid price data", "symbol", symbol, "price", currentPrice)
         return
     }
 
-    // Calculate quantity and format it with controlled precision
-    quantity := t.config.MaxPosition / currentPrice
-    quantityStr := formatQuantity(quantity)
-
-    log.Printf("Debug: Calculated quantity: %f", quantity)
-    log.Printf("Debug: Formatted quantity string: %s", quantityStr)
-
-    log.Printf("Attempting to enter long position for symbol: %s with quantity: %s", symbol, quantityStr)
+    notional, err := t.positionNotional(currentPrice)
+    if err != nil {
+        traderLog.Error("error sizing long position", "symbol", symbol, "err", err)
+        return
+    }
 
-    var err error
-    switch t.config.Market {
-    case "spot":
-        log.Printf("Debug: Using spot market")
-        _, err = t.spotClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(binance.SideTypeBuy).
-            Type(binance.OrderTypeMarket).
-            Quantity(quantityStr).
-            Do(context.Background())
-    case "usdm":
-        log.Printf("Debug: Using USDM futures market")
-        _, err = t.usdmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(futures.SideTypeBuy).
-            Type(futures.OrderTypeMarket).
-            Quantity(quantityStr).
-            Do(context.Background())
-    case "coinm":
-        log.Printf("Debug: Using COINM futures market")
-        _, err = t.coinmClient.NewCreateOrderService().
-            Symbol(symbol).
-            Side(delivery.SideTypeBuy).
-            Type(delivery.OrderTypeMarket).
-            Quantity(quantityStr).
-            Do(context.Background())
-    default:
-        err = fmt.Errorf("unsupported market type: %s", t.config.Market)
+    quantityStr, notional := t.orderQuantity(notional, currentPrice)
+    if notional <= 0 {
+        traderLog.Error("position size is below one lot", "symbol", symbol, "stepSize", t.symbol.filters.stepSize, "contractSize", t.symbol.contractSize)
+        return
     }
 
+    traderLog.Info("entering long position", "symbol", symbol, "price", currentPrice, "notional", notional, "quantity", quantityStr)
+
+    res, err := t.placeEntry(binance.SideTypeBuy, true, quantityStr)
     if err != nil {
-        log.Printf(color.RedString("Error entering long position: %v", err))
-        log.Printf("Debug: Error details: %+v", err)
+        traderLog.Error("error entering long position", "symbol", symbol, "quantity", quantityStr, "err", err)
         return
     }
+    currentPrice, notional = t.filledEntry(res, quantityStr, currentPrice, notional)
+
+    t.ledger.OpenPosition(t.config.Pair, t.config.Market, true, t.symbol.contractSize)
+    fills := t.recordFills(res, binance.SideTypeBuy, quantityStr, currentPrice)
 
+    stateBefore := t.state
     t.entryPrice = currentPrice
-    t.entrySize = t.config.MaxPosition
+    t.entrySize = notional
     t.currentSize = t.entrySize
     t.isLong = true
     t.state = InitialEntry
-    log.Printf(color.GreenString("Entered long position at price %f", currentPrice))
-    log.Printf("Debug: Exiting enterLongPosition function")
+    t.firedTiers = make(map[float64]bool)
+    t.recordAction(entryAction(stateBefore), binance.SideTypeBuy, fills, stateBefore, reason)
+    traderLog.Info("entered long position", "symbol", symbol, "price", currentPrice, "orderId", res.orderID, "state", t.state, "reason", reason)
 }
 
 
 
 
-func (t *Trader) enterShortPosition(currentPrice float64) {
+func (t *Trader) enterShortPosition(currentPrice float64, reason string) {
 	symbol := strings.ToUpper(t.config.Pair)
 
 	if symbol == "" {
-		log.Printf(color.RedString("Error: Symbol is empty"))
+		traderLog.Error("symbol is empty")
 		return
 	}
 
-	// Calculate quantity and format it with controlled precision
-	quantity := t.config.MaxPosition / currentPrice
-	quantityStr := formatQuantity(quantity)
-
-	log.Printf("Attempting to enter short position for symbol: %s with quantity: %s", symbol, quantityStr)
-
-	var err error
-	switch t.config.Market {
-	case "spot":
-		_, err = t.spotClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(binance.SideTypeSell).
-			Type(binance.OrderTypeMarket).
-			Quantity(quantityStr).
-			Do(context.Background())
-	case "usdm":
-		_, err = t.usdmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(futures.SideTypeSell).
-			Type(futures.OrderTypeMarket).
-			Quantity(quantityStr).
-			Do(context.Background())
-	case "coinm":
-		_, err = t.coinmClient.NewCreateOrderService().
-			Symbol(symbol).
-			Side(delivery.SideTypeSell).
-			Type(delivery.OrderTypeMarket).
-			Quantity(quantityStr).
-			Do(context.Background())
-	default:
-		err = fmt.Errorf("unsupported market type: %s", t.config.Market)
+	notional, err := t.positionNotional(currentPrice)
+	if err != nil {
+		traderLog.Error("error sizing short position", "symbol", symbol, "err", err)
+		return
+	}
+
+	quantityStr, notional := t.orderQuantity(notional, currentPrice)
+	if notional <= 0 {
+		traderLog.Error("position size is below one lot", "symbol", symbol, "stepSize", t.symbol.filters.stepSize, "contractSize", t.symbol.contractSize)
+		return
 	}
 
+	traderLog.Info("entering short position", "symbol", symbol, "price", currentPrice, "notional", notional, "quantity", quantityStr)
+
+	res, err := t.placeEntry(binance.SideTypeSell, false, quantityStr)
 	if err != nil {
-		log.Printf(color.RedString("Error entering short position: %v", err))
+		traderLog.Error("error entering short position", "symbol", symbol, "quantity", quantityStr, "err", err)
 		return
 	}
+	currentPrice, notional = t.filledEntry(res, quantityStr, currentPrice, notional)
 
+	t.ledger.OpenPosition(t.config.Pair, t.config.Market, false, t.symbol.contractSize)
+	fills := t.recordFills(res, binance.SideTypeSell, quantityStr, currentPrice)
+
+	stateBefore := t.state
 	t.entryPrice = currentPrice
-	t.entrySize = t.config.MaxPosition
+	t.entrySize = notional
 	t.currentSize = t.entrySize
 	t.isLong = false
 	t.state = InitialEntry
-	log.Printf(color.GreenString("Entered short position at price %f", currentPrice))
+	t.firedTiers = make(map[float64]bool)
+	t.recordAction(entryAction(stateBefore), binance.SideTypeSell, fills, stateBefore, reason)
+	traderLog.Info("entered short position", "symbol", symbol, "price", currentPrice, "orderId", res.orderID, "state", t.state, "reason", reason)
 }
 
 
+// ladderTier is one take-profit step: once price has moved gain from entry
+// in our favour, the position is reduced by fraction of the entry size.
+type ladderTier struct {
+	gain     float64
+	fraction float64
+}
+
+// Take-profit ladders, highest tier first.
+var (
+	initialLadder   = []ladderTier{{0.04, 0.25}, {0.03, 0.25}, {0.02, 0.25}, {0.01, 0.25}}
+	secondaryLadder = []ladderTier{{0.03, 0.25}, {0.02, 0.25}, {0.01, 0.5}}
+)
+
+func (t *Trader) ladder() []ladderTier {
+	if t.state == SecondaryEntry {
+		return secondaryLadder
+	}
+	return initialLadder
+}
+
+// takeProfit reduces the position at the highest tier priceDiff has reached
+// and reports whether one was reached.
+func (t *Trader) takeProfit(priceDiff, currentPrice float64) bool {
+	for _, tier := range t.ladder() {
+		if priceDiff >= tier.gain {
+			if t.reducePosition(tier.fraction, currentPrice, fmt.Sprintf("take profit +%.0f%%", tier.gain*100)) {
+				t.firedTiers[tier.gain] = true
+			}
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Trader) handleLongPosition(currentPrice float64) {
 	priceDiff := (currentPrice - t.entryPrice) / t.entryPrice
 
-	if priceDiff >= 0.04 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.03 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.02 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.01 {
-		t.reducePosition(0.25)
-	} else if priceDiff <= -0.01 {
-		t.closePosition()
-		t.enterShortPosition(currentPrice)
+	if t.takeProfit(priceDiff, currentPrice) {
+		return
+	}
+	if priceDiff <= -stopLossPct {
+		if t.closePosition(currentPrice, reasonStopLoss) {
+			t.enterShortPosition(currentPrice, "reversal after stop loss")
+		}
 	}
 }
 
 func (t *Trader) handleShortPosition(currentPrice float64) {
 	priceDiff := (t.entryPrice - currentPrice) / t.entryPrice
 
-	if priceDiff >= 0.04 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.03 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.02 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.01 {
-		t.reducePosition(0.25)
-	} else if priceDiff <= -0.01 {
-		t.closePosition()
-		t.enterLongPosition(currentPrice)
+	if t.takeProfit(priceDiff, currentPrice) {
+		return
+	}
+	if priceDiff <= -stopLossPct {
+		if t.closePosition(currentPrice, reasonStopLoss) {
+			t.enterLongPosition(currentPrice, "reversal after stop loss")
+		}
 	}
 }
 
 func (t *Trader) handleSecondaryLongPosition(currentPrice float64) {
 	priceDiff := (currentPrice - t.entryPrice) / t.entryPrice
 
-	if priceDiff >= 0.03 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.02 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.01 {
-		t.reducePosition(0.5)
-	} else if priceDiff <= -0.01 {
-		t.closePosition()
-		t.state = Idle
+	if t.takeProfit(priceDiff, currentPrice) {
+		return
+	}
+	if priceDiff <= -stopLossPct {
+		if t.closePosition(currentPrice, reasonStopLoss) {
+			t.state = Idle
+		}
 	}
 }
 
 func (t *Trader) handleSecondaryShortPosition(currentPrice float64) {
 	priceDiff := (t.entryPrice - currentPrice) / t.entryPrice
 
-	if priceDiff >= 0.03 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.02 {
-		t.reducePosition(0.25)
-	} else if priceDiff >= 0.01 {
-		t.reducePosition(0.5)
-	} else if priceDiff <= -0.01 {
-		t.closePosition()
-		t.state = Idle
+	if t.takeProfit(priceDiff, currentPrice) {
+		return
+	}
+	if priceDiff <= -stopLossPct {
+		if t.closePosition(currentPrice, reasonStopLoss) {
+			t.state = Idle
+		}
 	}
 }
 
-func (t *Trader) reducePosition(percentage float64) {
+// stopPrice is the price at which the handlers stop out the position.
+func (t *Trader) stopPrice() float64 {
+	if t.isLong {
+		return t.entryPrice * (1 - stopLossPct)
+	}
+	return t.entryPrice * (1 + stopLossPct)
+}
+
+func (t *Trader) reducePosition(percentage float64, currentPrice float64, reason string) bool {
 	reduceSize := t.entrySize * percentage
 	if reduceSize > t.currentSize {
 		reduceSize = t.currentSize
 	}
-	quantity := fmt.Sprintf("%.8f", reduceSize/t.entryPrice)
-	
-	var err error
-	if t.isLong {
-		err = t.PlaceMarketOrder(binance.SideTypeSell, quantity)
-	} else {
-		err = t.PlaceMarketOrder(binance.SideTypeBuy, quantity)
+	quantity, reduceSize := t.orderQuantity(reduceSize, t.entryPrice)
+	if reduceSize <= 0 {
+		traderLog.Warn("reduction is below one contract, skipped", "symbol", t.config.Pair, "pct", percentage*100, "reason", reason)
+		return false
 	}
 
+	side := binance.SideTypeBuy
+	if t.isLong {
+		side = binance.SideTypeSell
+	}
+	res, err := t.placeExit(actionReduce, side, quantity)
 	if err != nil {
-		log.Printf(color.RedString("Error reducing position: %v", err))
-		return
+		traderLog.Error("error reducing position", "symbol", t.config.Pair, "quantity", quantity, "err", err)
+		return false
+	}
+	if filled, partial := partialFill(res, quantity); partial {
+		reduceSize = t.exitNotional(filled)
+		traderLog.Warn("reduction only partly filled", "symbol", t.config.Pair, "quantity", quantity, "filled", filled)
 	}
 
+	stateBefore := t.state
 	t.currentSize -= reduceSize
-	log.Printf(color.YellowString("Reduced position by %f%%", percentage*100))
+	fills := t.recordFills(res, side, quantity, currentPrice)
+	traderLog.Info("reduced position", "symbol", t.config.Pair, "pct", percentage*100, "quantity", quantity, "orderId", res.orderID, "reason", reason)
 
 	if t.currentSize <= 0 {
 		t.state = Idle
+		t.recordPositionClosed()
 	}
+	t.recordAction(actionReduce, side, fills, stateBefore, reason)
+	return true
 }
 
-func (t *Trader) closePosition() {
-	quantity := fmt.Sprintf("%.8f", t.currentSize/t.entryPrice)
-	
-	var err error
+// closePosition closes the position and reports whether it is closed. A
+// close that only partly fills is booked as a reduction and leaves the
+// state alone.
+func (t *Trader) closePosition(currentPrice float64, reason string) bool {
+	quantity, _ := t.orderQuantity(t.currentSize, t.entryPrice)
+
+	side := binance.SideTypeBuy
 	if t.isLong {
-		err = t.PlaceMarketOrder(binance.SideTypeSell, quantity)
-	} else {
-		err = t.PlaceMarketOrder(binance.SideTypeBuy, quantity)
+		side = binance.SideTypeSell
 	}
-
+	res, err := t.placeExit(actionClose, side, quantity)
 	if err != nil {
-		log.Printf(color.RedString("Error closing position: %v", err))
-		return
+		traderLog.Error("error closing position", "symbol", t.config.Pair, "quantity", quantity, "err", err)
+		return false
 	}
 
+	stateBefore := t.state
+	if filled, partial := partialFill(res, quantity); partial {
+		t.currentSize = math.Max(t.currentSize-t.exitNotional(filled), 0)
+		fills := t.recordFills(res, side, formatQuantity(filled), currentPrice)
+		traderLog.Warn("close only partly filled, position remains open", "symbol", t.config.Pair, "quantity", quantity, "filled", filled, "orderId", res.orderID, "reason", reason)
+		t.recordAction(actionReduce, side, fills, stateBefore, reason)
+		return false
+	}
 	t.currentSize = 0
-	log.Printf(color.YellowString("Closed position"))
+	fills := t.recordFills(res, side, quantity, currentPrice)
+	t.recordPositionClosed()
+	traderLog.Info("closed position", "symbol", t.config.Pair, "quantity", quantity, "orderId", res.orderID, "reason", reason)
 
 	if t.state == InitialEntry {
 		t.state = SecondaryEntry
 	} else {
 		t.state = Idle
 	}
+	t.recordAction(actionClose, side, fills, stateBefore, reason)
+	return true
+}
+
+// exitNotional converts a filled exit quantity to the position notional it
+// took off, valued like orderQuantity at the entry price.
+func (t *Trader) exitNotional(quantity float64) float64 {
+	if t.config.Market == "coinm" {
+		return quantity * t.symbol.contractSize
+	}
+	return quantity * t.entryPrice
+}
+
+// Flatten cancels open orders and closes the position at market, leaving
+// the Trader Idle. The risk manager calls it when the kill switch trips.
+//
+// While a parent order is being worked, Flatten stops it and returns; the
+// Run loop flattens once the parent has settled its fills. It does the same
+// while an order waits to be retried.
+func (t *Trader) Flatten(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.parent != nil {
+		traderLog.Warn("stopping parent order to flatten", "symbol", t.config.Pair, "parentId", t.parent.id, "reason", reason)
+		t.parent.cancelled = "flatten: " + reason
+		t.flattenReason = reason
+		return
+	}
+	if t.retrying > 0 {
+		traderLog.Warn("flattening once the order being retried is settled", "symbol", t.config.Pair, "reason", reason)
+		t.flattenReason = reason
+		return
+	}
+	t.flatten(reason)
+}
+
+// flatten does the work of Flatten. Callers hold t.mu.
+func (t *Trader) flatten(reason string) {
+	traderLog.Warn("flattening", "symbol", t.config.Pair, "state", t.state, "reason", reason)
+	t.flattening = true
+	defer func() { t.flattening = false }()
+
+	if err := t.cancelAllOrders(); err != nil {
+		traderLog.Error("error cancelling open orders", "symbol", t.config.Pair, "err", err)
+	}
+
+	if t.state != Idle && t.currentSize > 0 {
+		price := t.entryPrice
+		if marketData := t.ds.GetMarketData(t.config.Pair); marketData != nil {
+			price = marketData.LastPrice()
+		}
+		t.closePosition(price, "flatten: "+reason)
+		if t.currentSize > 0 {
+			traderLog.Error("failed to flatten, position remains open", "symbol", t.config.Pair, "size", t.currentSize)
+			return
+		}
+	}
+	t.state = Idle
+}
+
+// updateMetrics refreshes the trader gauges. Callers hold t.mu.
+func (t *Trader) updateMetrics(price float64) {
+	pnl := t.ledger.Summary(price)
+	notional := t.currentSize
+	if !t.isLong {
+		notional = -notional
+	}
+
+	traderState.WithLabelValues(t.config.Pair).Set(float64(t.state))
+	positionNotional.WithLabelValues(t.config.Pair).Set(notional)
+	pnlUnrealized.WithLabelValues(t.config.Pair).Set(pnl.UnrealizedPnL)
+	pnlSessionNet.WithLabelValues(t.config.Pair).Set(pnl.Session.Net())
+	pnlLifetimeNet.WithLabelValues(t.config.Pair).Set(pnl.Lifetime.Net())
+}
+
+// TraderStatus is a snapshot of a Trader for the control API.
+type TraderStatus struct {
+	Symbol      string        `json:"symbol"`
+	Market      string        `json:"market"`
+	Environment string        `json:"environment"`
+	DryRun      bool          `json:"dry_run,omitempty"`
+	State       string        `json:"state"`
+	Paused      bool          `json:"paused"`
+	Halted      bool          `json:"halted"`
+	Direction   string        `json:"direction"`
+	EntrySignal string        `json:"entry_signal"`
+	EntryPrice  float64       `json:"entry_price"`
+	Position    float64       `json:"position_notional"`
+	StopPrice   float64       `json:"stop_price,omitempty"`
+	Ladder      []TierStatus  `json:"ladder,omitempty"`
+	LastPrice   float64       `json:"last_price"`
+	PnL         LedgerSummary `json:"pnl"`
+
+	ParentOrder *ParentOrderStatus `json:"parent_order,omitempty"`
+	OpenOrders  []OpenOrder        `json:"open_orders,omitempty"`
+	Clock       ClockStatus        `json:"clock"`
+}
+
+// TierStatus is one take-profit tier of the current ladder.
+type TierStatus struct {
+	GainPct  float64 `json:"gain_pct"`
+	Fraction float64 `json:"fraction"`
+	Price    float64 `json:"price"`
+	Fired    bool    `json:"fired"`
+}
+
+func (t *Trader) Status() TraderStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lastPrice float64
+	if marketData := t.ds.GetMarketData(t.config.Pair); marketData != nil {
+		lastPrice = marketData.LastPrice()
+	}
+	direction := "short"
+	if t.isLong {
+		direction = "long"
+	}
+	status := TraderStatus{
+		Symbol:      strings.ToUpper(t.config.Pair),
+		Market:      t.config.Market,
+		Environment: t.env.Name,
+		DryRun:      t.config.DryRun,
+		State:       t.state.String(),
+		Paused:      t.paused,
+		Halted:      t.risk.Halted(),
+		Direction:   direction,
+		EntrySignal: t.config.EntrySignal,
+		EntryPrice:  t.entryPrice,
+		Position:    t.currentSize,
+		LastPrice:   lastPrice,
+		PnL:         t.ledger.Summary(lastPrice),
+		Clock:       serverClock.Status(),
+	}
+	if t.parent != nil {
+		status.ParentOrder = t.parent.status()
+	}
+	if len(t.orders.orders) > 0 {
+		status.OpenOrders = t.orders.list()
+	}
+	if t.state != Idle {
+		status.StopPrice = t.stopPrice()
+		for _, tier := range t.ladder() {
+			price := t.entryPrice * (1 + tier.gain)
+			if !t.isLong {
+				price = t.entryPrice * (1 - tier.gain)
+			}
+			status.Ladder = append(status.Ladder, TierStatus{
+				GainPct:  tier.gain * 100,
+				Fraction: tier.fraction,
+				Price:    price,
+				Fired:    t.firedTiers[tier.gain],
+			})
+		}
+	}
+	return status
+}
+
+// Pause stops the state handlers: no entries, take profits or stops run
+// until Resume. An open position stays open.
+func (t *Trader) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused = true
+}
+
+func (t *Trader) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused = false
+}
+
+// SetEntrySignal changes the entry signal used by the next entry: "market"
+// or a price.
+func (t *Trader) SetEntrySignal(signal string) error {
+	if signal != "market" {
+		price, err := strconv.ParseFloat(signal, 64)
+		if err != nil || price <= 0 {
+			return fmt.Errorf("entry signal must be \"market\" or a positive price: %q", signal)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.config.EntrySignal = signal
+	return nil
+}
+
+// SetDirection changes the direction of the next entry. It is refused while
+// a position is open, since the state handlers assume isLong matches it, and
+// while an order is being worked or retried: the state is still Idle during
+// an entry, which sets isLong once it fills.
+func (t *Trader) SetDirection(long bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != Idle {
+		return fmt.Errorf("cannot change direction in state %s, flatten first", t.state)
+	}
+	if t.parent != nil {
+		return fmt.Errorf("cannot change direction while parent order %d is worked, cancel it first", t.parent.id)
+	}
+	if t.retrying > 0 {
+		return fmt.Errorf("cannot change direction while an order is being retried")
+	}
+	t.isLong = long
+	return nil
+}
+
+func (t *Trader) unrealizedPnL(price float64) float64 {
+	return t.ledger.Unrealized(price)
+}
+
+// fillSummary aggregates the fills of one order.
+type fillSummary struct {
+	quantity    float64
+	avgPrice    float64
+	fees        float64
+	realizedPnL float64 // net of fees
+	estimate    *FillEstimate
+}
+
+// recordFills books an order's fills in the ledger and passes their net
+// realized PnL (including fees) on to the risk manager.
+func (t *Trader) recordFills(res *orderResult, side binance.SideType, quantity string, price float64) fillSummary {
+	var sum fillSummary
+	var cost float64
+	for _, f := range orderFills(res, side, quantity, price) {
+		pnl := t.ledger.RecordFill(f)
+		t.risk.RecordRealized(pnl)
+		sum.quantity += f.Quantity
+		sum.fees += f.Fee
+		sum.realizedPnL += pnl
+		cost += f.Price * f.Quantity
+	}
+	if sum.quantity > 0 {
+		sum.avgPrice = cost / sum.quantity
+	}
+	sum.estimate = res.estimate
+	return sum
+}
+
+// recordAction writes a completed action to the trade journal and sends a
+// notification for it. Callers invoke it after updating t.state.
+func (t *Trader) recordAction(action string, side binance.SideType, fills fillSummary, stateBefore TraderState, reason string) {
+	var estSlippage, slippage float64
+	if e := fills.estimate; e != nil && e.TouchPrice > 0 {
+		estSlippage = e.SlippageBps
+		if fills.avgPrice > 0 {
+			slippage = slippageBps(side == binance.SideTypeBuy, e.TouchPrice, fills.avgPrice)
+		}
+	}
+
+	t.journal.Record(JournalEntry{
+		Time:        time.Now(),
+		Symbol:      strings.ToUpper(t.config.Pair),
+		Market:      t.config.Market,
+		Action:      action,
+		Side:        string(side),
+		Quantity:    fills.quantity,
+		FillPrice:   fills.avgPrice,
+		Fees:        fills.fees,
+		StateBefore: stateBefore.String(),
+		StateAfter:  t.state.String(),
+		Reason:      reason,
+		RealizedPnL: fills.realizedPnL,
+
+		EstSlippageBps: estSlippage,
+		SlippageBps:    slippage,
+	})
+
+	event := action
+	if action == actionClose && reason == reasonStopLoss {
+		event = eventStopOut
+	}
+	t.notifier.Notify(Notification{
+		Event:  event,
+		Symbol: strings.ToUpper(t.config.Pair),
+		Market: t.config.Market,
+		Text: fmt.Sprintf("%s %s: %s %.8g @ %.8g (%s), state %s -> %s, realized %.4f",
+			strings.ToUpper(t.config.Pair), event, side, fills.quantity, fills.avgPrice, reason, stateBefore, t.state, fills.realizedPnL),
+		Fields: map[string]interface{}{
+			"side":         string(side),
+			"quantity":     fills.quantity,
+			"fill_price":   fills.avgPrice,
+			"fees":         fills.fees,
+			"realized_pnl": fills.realizedPnL,
+			"state_before": stateBefore.String(),
+			"state_after":  t.state.String(),
+			"reason":       reason,
+		},
+	})
+}
+
+// entryAction tells a fresh entry from the re-entry after a stop, which
+// closePosition leaves in SecondaryEntry.
+func entryAction(stateBefore TraderState) string {
+	if stateBefore == Idle {
+		return actionEntry
+	}
+	return actionReversal
+}
+
+func (t *Trader) recordPositionClosed() {
+	t.risk.RecordPositionClosed(t.ledger.ClosePosition())
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// newTestTrader returns a dry-run spot Trader on BTCUSDT whose state lives
+// in a temporary directory. It never touches the network.
+func newTestTrader(t *testing.T, config *Config) *Trader {
+	t.Helper()
+	if config == nil {
+		config = &Config{}
+	}
+	config.Pair, config.Market, config.DryRun = "btcusdt", "spot", true
+	dir := t.TempDir()
+
+	notifier, err := NewNotifier(NotifyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(notifier.Close)
+	risk, err := NewRiskManager(config.Risk, dir, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := LoadLedger(filepath.Join(dir, "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Trader{
+		config:     config,
+		env:        resolveEnvironment(config),
+		ds:         NewDataStore(),
+		risk:       risk,
+		ledger:     ledger,
+		notifier:   notifier,
+		state:      Idle,
+		isLong:     true,
+		leverage:   1,
+		firedTiers: make(map[float64]bool),
+		orders:     NewOrderManager(config, ledger),
+		symbol:     symbolInfo{baseAsset: "BTC", quoteAsset: "USDT"},
+	}
+}
+
+// setBook replaces the test Trader's book with 5 lots at bid and at ask.
+func setBook(t *testing.T, tr *Trader, bid, ask string) {
+	t.Helper()
+	err := tr.ds.UpdateBookTicker(tr.config.Pair, &BookTickerEvent{BidPrice: bid, BidQty: "5", AskPrice: ask, AskQty: "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tr.ds.UpdateDepth(tr.config.Pair, &DepthEvent{LastUpdateID: 1, Bids: [][2]string{{bid, "5"}}, Asks: [][2]string{{ask, "5"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpreadGuardSkipsExits(t *testing.T) {
+	tr := newTestTrader(t, &Config{MaxSpreadBps: 5})
+	setBook(t, tr, "99", "101") // 200 bps wide
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	_, err := tr.submitOrder(orderParams{side: binance.SideTypeBuy, orderType: orderTypeMarket, quantity: "0.01", positionLong: true})
+	if err == nil || !strings.Contains(err.Error(), "spread") {
+		t.Fatalf("opening order on a wide book: got %v, want a spread error", err)
+	}
+
+	res, err := tr.submitOrder(orderParams{side: binance.SideTypeSell, orderType: orderTypeMarket, quantity: "0.01", positionLong: true, reducing: true})
+	if err != nil {
+		t.Fatalf("reducing order on a wide book: %v", err)
+	}
+	if res.executedQty != 0.01 || res.avgPrice != 99 {
+		t.Errorf("reducing order filled %v at %v, want 0.01 at 99", res.executedQty, res.avgPrice)
+	}
+}
+
+func TestSlippageGuardSkipsExits(t *testing.T) {
+	tr := newTestTrader(t, &Config{Execution: ExecutionConfig{MaxSlippageBps: 10}})
+	setBook(t, tr, "99", "101") // 5 lots on each side
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	_, err := tr.submitOrder(orderParams{side: binance.SideTypeBuy, orderType: orderTypeMarket, quantity: "8", positionLong: true})
+	if err == nil || !strings.Contains(err.Error(), "visible book") {
+		t.Fatalf("opening order past the book: got %v, want a slippage error", err)
+	}
+
+	res, err := tr.submitOrder(orderParams{side: binance.SideTypeSell, orderType: orderTypeMarket, quantity: "8", positionLong: true, reducing: true})
+	if err != nil {
+		t.Fatalf("reducing order past the book: %v", err)
+	}
+	if res.estimate == nil || res.estimate.Covered != 5 {
+		t.Errorf("reducing order estimate %+v, want one covering 5", res.estimate)
+	}
+}
+
+func TestDryRunLimitOrderRestsUntilCrossed(t *testing.T) {
+	tr := newTestTrader(t, nil)
+	setBook(t, tr, "99", "101")
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	// Reducing sells skip the balance check, which needs an account.
+	sell := orderParams{side: binance.SideTypeSell, orderType: orderTypeLimit, quantity: "0.01", price: "100", positionLong: true, reducing: true}
+
+	res, err := tr.submitOrder(sell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.status != "NEW" || res.executedQty != 0 {
+		t.Fatalf("sell at 100 under a 99 bid: got %s with %v filled, want it resting", res.status, res.executedQty)
+	}
+	if len(tr.orders.list()) != 1 {
+		t.Fatalf("tracking %d orders, want the resting one", len(tr.orders.list()))
+	}
+	if res, err = tr.fetchOrder(res.orderID, sell.side); err != nil || res.status != "NEW" {
+		t.Fatalf("before the bid reaches 100: got %+v, %v; want it resting", res, err)
+	}
+
+	setBook(t, tr, "100.5", "102")
+	if res, err = tr.fetchOrder(res.orderID, sell.side); err != nil {
+		t.Fatal(err)
+	}
+	if res.status != "FILLED" || res.executedQty != 0.01 || res.avgPrice != 100 {
+		t.Errorf("after the bid crossed 100: got %s, %v at %v; want 0.01 filled at the limit price", res.status, res.executedQty, res.avgPrice)
+	}
+	if len(tr.orders.list()) != 0 {
+		t.Errorf("still tracking %d orders after the fill", len(tr.orders.list()))
+	}
+
+	// Cancelled before the touch reaches it, it never fills.
+	setBook(t, tr, "99", "101")
+	res, err = tr.submitOrder(sell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.cancelOrder(res.orderID); err != nil {
+		t.Fatal(err)
+	}
+	setBook(t, tr, "100.5", "102")
+	if res, err = tr.fetchOrder(res.orderID, sell.side); err != nil || res.status != "CANCELED" || res.executedQty != 0 {
+		t.Errorf("cancelled order: got %+v, %v; want it cancelled unfilled", res, err)
+	}
+
+	// A post-only order that would take is rejected, as spot does.
+	sell.postOnly, sell.price = true, "100"
+	if _, err := tr.submitOrder(sell); !wouldTake(err) {
+		t.Errorf("post-only sell under the bid: got %v, want a would-take rejection", err)
+	}
+}
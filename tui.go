@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+const (
+	tuiRefresh    = 500 * time.Millisecond
+	tuiBookLevels = 10
+	tuiLogLines   = 200
+)
+
+// TUI is a full-screen terminal dashboard for one Trader: top of book,
+// recent trades, position and ladder progress, and the latest log events.
+// While it runs, log output goes to a logRing instead of stderr.
+type TUI struct {
+	trader *Trader
+	ds     *DataStore
+	logs   *logRing
+	out    io.Writer
+
+	confirmFlatten bool
+	message        string
+}
+
+func NewTUI(trader *Trader, ds *DataStore, logs *logRing) *TUI {
+	return &TUI{trader: trader, ds: ds, logs: logs, out: os.Stdout}
+}
+
+// Run draws the dashboard until the user quits. Keys: p pause/resume,
+// c cancel the working parent order, f flatten (confirmed with y), q or
+// Ctrl-C quit.
+func (u *TUI) Run() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("TUI mode needs an interactive terminal")
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("error entering raw mode: %v", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	// Alternate screen, cursor hidden.
+	fmt.Fprint(u.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(u.out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+	u.render()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || u.handleKey(key) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+		u.render()
+	}
+}
+
+// handleKey applies a key press and reports whether to quit.
+func (u *TUI) handleKey(key byte) bool {
+	if u.confirmFlatten {
+		u.confirmFlatten = false
+		if key == 'y' || key == 'Y' {
+			u.message = "Flattening..."
+			go func() {
+				u.trader.Pause()
+				u.trader.Flatten("manual flatten from TUI")
+			}()
+		} else {
+			u.message = "Flatten cancelled"
+		}
+		return false
+	}
+
+	switch key {
+	case 'q', 'Q', 3: // 3 is Ctrl-C in raw mode
+		return true
+	case 'p', 'P':
+		if u.trader.Status().Paused {
+			u.trader.Resume()
+			u.message = "Resumed"
+		} else {
+			u.trader.Pause()
+			u.message = "Paused"
+		}
+	case 'c', 'C':
+		if u.trader.CancelParentOrder() {
+			u.message = "Parent order cancelled"
+		} else {
+			u.message = "No parent order to cancel"
+		}
+	case 'f', 'F':
+		u.confirmFlatten = true
+		u.message = "Flatten position and pause? (y/n)"
+	}
+	return false
+}
+
+func (u *TUI) render() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 100, 40
+	}
+
+	status := u.trader.Status()
+	var bids, asks [][2]float64
+	var trades []TradeTick
+	if md := u.ds.GetMarketData(u.trader.config.Pair); md != nil {
+		bids, asks = md.Book()
+		trades = md.Trades()
+	}
+
+	bold := color.New(color.Bold).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	faint := color.New(color.Faint).SprintFunc()
+
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	flags := ""
+	switch status.Environment {
+	case envMainnet:
+		flags += " " + red("[MAINNET]")
+	case envTestnet:
+		flags += " " + yellow("[TESTNET]")
+	default:
+		flags += " " + yellow("[CUSTOM ENDPOINTS]")
+	}
+	if status.DryRun {
+		flags += " " + yellow("[DRY RUN]")
+	}
+	if status.Paused {
+		flags += " " + yellow("[PAUSED]")
+	}
+	if status.Halted {
+		flags += " " + red("[HALTED]")
+	}
+	add("%s  %s %s  %s%s", bold("simpleStrat"), bold(status.Symbol), status.Market, time.Now().Format(time.TimeOnly), flags)
+	add("State: %s  Direction: %s  Entry signal: %s", bold(status.State), status.Direction, status.EntrySignal)
+	if status.State == Idle.String() {
+		add("Last: %.8g", status.LastPrice)
+	} else {
+		add("Entry: %.8g  Size: %.2f  Last: %.8g  Stop: %s", status.EntryPrice, status.Position, status.LastPrice, red(fmt.Sprintf("%.8g", status.StopPrice)))
+	}
+	if po := status.ParentOrder; po != nil {
+		add("Working: %s %s %s %.8g/%.8g (%.0f%%) in %d orders, %s", po.Algo, po.Action, po.Side, po.Filled, po.Quantity,
+			po.Filled/po.Quantity*100, po.Children, time.Since(po.Started).Round(time.Second))
+	}
+	add("PnL: unrealized %s  session net %s  lifetime net %s",
+		signed(status.PnL.UnrealizedPnL), signed(status.PnL.Session.Net()), signed(status.PnL.Lifetime.Net()))
+	add("")
+
+	if len(status.Ladder) > 0 {
+		add(bold("Take-profit ladder"))
+		for _, tier := range status.Ladder {
+			mark := faint("pending")
+			if tier.Fired {
+				mark = green("fired")
+			}
+			add("  +%.0f%%  reduce %3.0f%%  @ %-14.8g %s", tier.GainPct, tier.Fraction*100, tier.Price, mark)
+		}
+		add("")
+	}
+
+	add(bold(fmt.Sprintf("%-28s  %-28s  %s", "Bids", "Asks", "Trades")))
+	for i := 0; i < tuiBookLevels; i++ {
+		bid := fmt.Sprintf("%-28s", "")
+		if i < len(bids) {
+			bid = green(fmt.Sprintf("%14.8g %-13.6g", bids[i][0], bids[i][1]))
+		}
+		ask := fmt.Sprintf("%-28s", "")
+		if i < len(asks) {
+			ask = red(fmt.Sprintf("%14.8g %-13.6g", asks[i][0], asks[i][1]))
+		}
+		trade := ""
+		if j := len(trades) - 1 - i; j >= 0 {
+			tr := trades[j]
+			s := fmt.Sprintf("%s %14.8g %.6g", tr.Time.Format(time.TimeOnly), tr.Price, tr.Quantity)
+			if tr.BuyerMaker {
+				trade = red(s)
+			} else {
+				trade = green(s)
+			}
+		}
+		add("%s  %s  %s", bid, ask, trade)
+	}
+	add("")
+
+	footer := faint("p pause/resume  c cancel order  f flatten  q quit (an open position stays open)")
+	if u.message != "" {
+		footer = yellow(u.message) + "  " + footer
+	}
+
+	add(bold("Events"))
+	logRows := height - len(lines) - 1
+	for _, l := range u.logs.Last(logRows) {
+		add("%s", l)
+	}
+	for len(lines) < height-1 {
+		add("")
+	}
+	lines = append(lines, footer)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines {
+		if i >= height {
+			break
+		}
+		b.WriteString(fitWidth(l, width))
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 && i < height-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(u.out, b.String())
+}
+
+func signed(v float64) string {
+	s := fmt.Sprintf("%+.4f", v)
+	switch {
+	case v > 0:
+		return color.GreenString(s)
+	case v < 0:
+		return color.RedString(s)
+	default:
+		return s
+	}
+}
+
+// fitWidth truncates s to width visible columns, skipping ANSI escape
+// sequences when counting.
+func fitWidth(s string, width int) string {
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			j := i + 1
+			if j < len(s) && s[j] == '[' {
+				j++
+				for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+					j++
+				}
+				j++
+			}
+			i = j
+			continue
+		}
+		if visible == width {
+			return s[:i] + "\x1b[0m"
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		visible++
+	}
+	return s
+}
+
+// logRing keeps the last log lines written to it. It is the log writer
+// while the TUI runs.
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.lines = append(r.lines, string(r.partial[:i]))
+		r.partial = r.partial[i+1:]
+	}
+	if len(r.lines) > tuiLogLines {
+		r.lines = append(r.lines[:0], r.lines[len(r.lines)-tuiLogLines:]...)
+	}
+	return len(p), nil
+}
+
+// Last returns up to n of the most recent lines, oldest first.
+func (r *logRing) Last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 {
+		return nil
+	}
+	if n > len(r.lines) {
+		n = len(r.lines)
+	}
+	return append([]string(nil), r.lines[len(r.lines)-n:]...)
+}
@@ -2,11 +2,12 @@
 package main
 
 import (
-    "encoding/json"
+    "context"
     "fmt"
-    "log"
-    "net/url"
+    "log/slog"
     "strings"
+    "sync"
+    "time"
 
     "github.com/gorilla/websocket"
 )
@@ -15,8 +16,18 @@ type WebSocket struct {
     config *Config
     conn   *websocket.Conn
     ds     *DataStore
+
+    mu     sync.Mutex // guards conn and closed against Close
+    closed bool
 }
 
+// Reconnect backoff after the connection drops: it doubles from the first
+// to the last value while dials keep failing.
+const (
+    wsReconnectMinBackoff = time.Second
+    wsReconnectMaxBackoff = time.Minute
+)
+
 func NewWebSocket(config *Config, ds *DataStore) (*WebSocket, error) {
     return &WebSocket{
         config: config,
@@ -25,76 +36,146 @@ func NewWebSocket(config *Config, ds *DataStore) (*WebSocket, error) {
 }
 
 func (ws *WebSocket) Connect() error {
-    baseURL := BINANCE_WS_BASE_URL_MAP[ws.config.Market]
-    streams := ws.getStreams()
-    u := url.URL{Scheme: "wss", Host: baseURL, Path: "stream", RawQuery: fmt.Sprintf("streams=%s", strings.Join(streams, "/"))}
-    log.Printf("Connecting to %s", u.String())
+    if err := ws.dial(); err != nil {
+        return err
+    }
+
+    go ws.readMessages()
+
+    return nil
+}
 
-    c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+// dial opens the combined stream connection.
+func (ws *WebSocket) dial() error {
+    streamURL, err := resolveEnvironment(ws.config).StreamURL(ws.getStreams())
     if err != nil {
-        return fmt.Errorf("dial error: %v", err)
+        return err
     }
-    ws.conn = c
+    wsLog.Info("connecting", "url", streamURL)
 
-    log.Println("WebSocket connection established")
+    c, _, err := websocket.DefaultDialer.Dial(streamURL, nil)
+    if err != nil {
+        return fmt.Errorf("dial error: %v", err)
+    }
 
-    go ws.readMessages()
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    if ws.closed {
+        c.Close()
+        return fmt.Errorf("WebSocket closed")
+    }
+    ws.conn = c
+    wsConnects.Inc()
 
+    wsLog.Info("connection established")
     return nil
 }
 
 func (ws *WebSocket) Close() {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+
+    ws.closed = true
     if ws.conn != nil {
         ws.conn.Close()
     }
 }
 
-func (ws *WebSocket) readMessages() {
+// reconnect dials again with backoff until it succeeds, and reports false
+// once the WebSocket has been closed.
+func (ws *WebSocket) reconnect() bool {
+    backoff := wsReconnectMinBackoff
     for {
-        _, message, err := ws.conn.ReadMessage()
-        if err != nil {
-            log.Println("read error:", err)
-            return
+        ws.mu.Lock()
+        closed := ws.closed
+        ws.mu.Unlock()
+        if closed {
+            return false
         }
 
-        var streamData struct {
-            Stream string          `json:"stream"`
-            Data   json.RawMessage `json:"data"`
+        wsLog.Warn("reconnecting", "backoff", backoff)
+        time.Sleep(backoff)
+        err := ws.dial()
+        if err == nil {
+            wsReconnects.Inc()
+            return true
         }
+        wsLog.Error("reconnect failed", "err", err)
+        backoff = min(backoff*2, wsReconnectMaxBackoff)
+    }
+}
 
-        err = json.Unmarshal(message, &streamData)
+func (ws *WebSocket) readMessages() {
+    var decoder frameDecoder
+
+    for {
+        _, message, err := ws.conn.ReadMessage()
         if err != nil {
-            log.Printf("Error unmarshaling message: %v", err)
+            ws.mu.Lock()
+            closed := ws.closed
+            ws.mu.Unlock()
+            if closed {
+                return
+            }
+
+            // Market data stops until the stream is back; streamAge shows
+            // how long it has been.
+            wsLog.Error("read error, market data stale until reconnected", "err", err)
+            wsDisconnects.Inc()
+            ws.conn.Close()
+            if !ws.reconnect() {
+                return
+            }
             continue
         }
 
-        var data map[string]interface{}
-        err = json.Unmarshal(streamData.Data, &data)
+        stream, kind, event, err := decoder.Decode(message)
         if err != nil {
-            log.Printf("Error unmarshaling data: %v", err)
+            wsLog.Warn("error decoding message", "err", err)
+            wsErrors.WithLabelValues("decode").Inc()
             continue
         }
+        wsMessages.WithLabelValues(stream).Inc()
+        streamAge.Seen(stream, time.Now())
 
-        ws.processMessage(streamData.Stream, data)
+        err = ws.processMessage(stream, kind, event)
+        if err != nil {
+            wsLog.Warn("error processing message", "stream", stream, "err", err)
+            wsErrors.WithLabelValues("process").Inc()
+        }
     }
 }
 
-func (ws *WebSocket) processMessage(stream string, data map[string]interface{}) {
+func (ws *WebSocket) processMessage(stream string, kind streamKind, event interface{}) error {
     symbol := ws.config.Pair
 
-    switch {
-    case strings.HasSuffix(stream, "@trade"):
-        ws.ds.UpdateTrade(symbol, data)
-        log.Printf("Updated trade data for %s", symbol)
-    case strings.HasSuffix(stream, "@aggTrade"):
-        ws.ds.UpdateAggTrade(symbol, data)
-        log.Printf("Updated aggTrade data for %s", symbol)
-    case strings.HasSuffix(stream, "@depth10"):
-        ws.ds.UpdateDepth(symbol, data)
-        log.Printf("Updated depth for %s", symbol)
+    var err error
+    switch kind {
+    case streamTrade:
+        err = ws.ds.UpdateTrade(symbol, event.(*TradeEvent))
+    case streamAggTrade:
+        err = ws.ds.UpdateAggTrade(symbol, event.(*AggTradeEvent))
+    case streamDepth:
+        err = ws.ds.UpdateDepth(symbol, event.(*DepthEvent))
+    case streamBookTicker:
+        err = ws.ds.UpdateBookTicker(symbol, event.(*BookTickerEvent))
+    case streamMarkPrice:
+        err = ws.ds.UpdateMarkPrice(symbol, event.(*MarkPriceEvent))
+    case streamKline:
+        err = ws.ds.UpdateKline(symbol, event.(*KlineEvent))
     default:
-        log.Printf("Unknown stream type: %s", stream)
+        wsLog.Warn("unknown stream type", "stream", stream)
+        return nil
     }
+    if err != nil {
+        return err
+    }
+
+    // One line per message; only enabled at debug level.
+    if wsLog.Enabled(context.Background(), slog.LevelDebug) {
+        wsLog.Debug("processed message", "symbol", symbol, "stream", stream)
+    }
+    return nil
 }
 
 func (ws *WebSocket) getStreams() []string {
@@ -105,14 +186,21 @@ func (ws *WebSocket) getStreams() []string {
         streams = []string{
             fmt.Sprintf("%s@trade", strings.ToLower(ws.config.Pair)),
             fmt.Sprintf("%s@depth10", strings.ToLower(ws.config.Pair)),
+            fmt.Sprintf("%s@bookTicker", strings.ToLower(ws.config.Pair)),
         }
     case "usdm", "coinm":
         streams = []string{
             fmt.Sprintf("%s@aggTrade", strings.ToLower(ws.config.Pair)),
             fmt.Sprintf("%s@depth10@100ms", strings.ToLower(ws.config.Pair)),
+            fmt.Sprintf("%s@bookTicker", strings.ToLower(ws.config.Pair)),
+            fmt.Sprintf("%s@markPrice@1s", strings.ToLower(ws.config.Pair)),
         }
     }
 
-    log.Printf("Subscribing to streams: %v", streams)
+    if ws.config.SizingModel == sizingVolatility {
+        streams = append(streams, fmt.Sprintf("%s@kline_1m", strings.ToLower(ws.config.Pair)))
+    }
+
+    wsLog.Info("subscribing", "streams", streams)
     return streams
 }
\ No newline at end of file